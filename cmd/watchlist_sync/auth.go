@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+)
+
+// endpoints used by the container orchestrator, never authenticated
+var publicPaths = []string{"/healthz", "/readyz"}
+
+type scopeKey struct{}
+
+// requestScope returns the scope authMiddleware granted to the request, "" if none
+func requestScope(r *http.Request) string {
+	scope, _ := r.Context().Value(scopeKey{}).(string)
+	return scope
+}
+
+func withScope(r *http.Request, scope string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), scopeKey{}, scope))
+}
+
+// secretsEqual compares hashes so that neither the content nor the length of the
+// expected secret leaks through the comparison time
+func secretsEqual(given string, expected string) bool {
+	g := sha256.Sum256([]byte(given))
+	e := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(g[:], e[:]) == 1
+}
+
+// authenticate returns the scope granted to the request, "" if none
+func authenticate(r *http.Request, auth c.AuthConfig) string {
+	scope := ""
+
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		// go through all keys to not leak which one matched
+		for _, k := range auth.ApiKeys {
+			if secretsEqual(key, k.Key) {
+				scope = k.Scope
+			}
+		}
+		return scope
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		for _, u := range auth.Users {
+			userOk := secretsEqual(username, u.Username)
+			passwordOk := secretsEqual(password, u.Password)
+			if userOk && passwordOk {
+				scope = u.Scope
+			}
+		}
+	}
+	return scope
+}
+
+func allowed(scope string, method string) bool {
+	switch scope {
+	case c.SCOPE_ADMIN:
+		return true
+	case c.SCOPE_READ:
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return false
+}
+
+func authMiddleware(next http.Handler, auth c.AuthConfig) http.Handler {
+	// anyone on the network must not be able to trigger syncs or change overrides
+	open := len(auth.ApiKeys) == 0 && len(auth.Users) == 0
+	if open {
+		log.Println("WARNING: no API key or user configured in server.auth, the API is read-only and not authenticated")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := authenticate(r, auth)
+		if open {
+			scope = c.SCOPE_READ
+		}
+
+		// public endpoints may still give more details to authenticated callers
+		for _, path := range append(publicPaths, webhookPaths...) {
+			if r.URL.Path == path {
+				next.ServeHTTP(w, withScope(r, scope))
+				return
+			}
+		}
+
+		if scope == "" {
+			if len(auth.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="lbxd_seerr", charset="UTF-8"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !allowed(scope, r.Method) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, withScope(r, scope))
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := c.AuthConfig{
+		ApiKeys: []c.ApiKeyConfig{
+			{Key: "read-key", Scope: c.SCOPE_READ},
+			{Key: "admin-key", Scope: c.SCOPE_ADMIN},
+		},
+		Users: []c.UserConfig{
+			{Username: "user", Password: "secret", Scope: c.SCOPE_ADMIN},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		auth      c.AuthConfig
+		method    string
+		path      string
+		apiKey    string
+		user      string
+		password  string
+		wantCode  int
+		wantScope string
+	}{
+		{name: "healthz is public", auth: auth, method: http.MethodGet, path: "/healthz", wantCode: http.StatusOK},
+		{name: "readyz is public", auth: auth, method: http.MethodGet, path: "/readyz", wantCode: http.StatusOK},
+		{name: "readyz gets the scope", auth: auth, method: http.MethodGet, path: "/readyz", apiKey: "read-key", wantCode: http.StatusOK, wantScope: c.SCOPE_READ},
+		{name: "readyz with a bad key", auth: auth, method: http.MethodGet, path: "/readyz", apiKey: "wrong", wantCode: http.StatusOK},
+		{name: "jellyseerr webhook", auth: auth, method: http.MethodPost, path: "/webhooks/jellyseerr", wantCode: http.StatusOK},
+		{name: "jellyfin webhook", auth: auth, method: http.MethodPost, path: "/webhooks/jellyfin", wantCode: http.StatusOK},
+		{name: "no credentials", auth: auth, method: http.MethodGet, path: "/films", wantCode: http.StatusUnauthorized},
+		{name: "bad api key", auth: auth, method: http.MethodGet, path: "/films", apiKey: "wrong", wantCode: http.StatusUnauthorized},
+		{name: "bad password", auth: auth, method: http.MethodGet, path: "/films", user: "user", password: "wrong", wantCode: http.StatusUnauthorized},
+		{name: "unknown user", auth: auth, method: http.MethodGet, path: "/films", user: "other", password: "secret", wantCode: http.StatusUnauthorized},
+		{name: "read key on GET", auth: auth, method: http.MethodGet, path: "/films", apiKey: "read-key", wantCode: http.StatusOK, wantScope: c.SCOPE_READ},
+		{name: "read key on POST", auth: auth, method: http.MethodPost, path: "/runs/dl_watchlist", apiKey: "read-key", wantCode: http.StatusForbidden},
+		{name: "admin key on POST", auth: auth, method: http.MethodPost, path: "/runs/dl_watchlist", apiKey: "admin-key", wantCode: http.StatusOK, wantScope: c.SCOPE_ADMIN},
+		{name: "basic auth", auth: auth, method: http.MethodPost, path: "/films/1/force", user: "user", password: "secret", wantCode: http.StatusOK, wantScope: c.SCOPE_ADMIN},
+		{name: "no auth configured on GET", method: http.MethodGet, path: "/films", wantCode: http.StatusOK, wantScope: c.SCOPE_READ},
+		{name: "no auth configured on POST", method: http.MethodPost, path: "/runs/dl_watchlist", wantCode: http.StatusForbidden},
+		{name: "no auth configured on force", method: http.MethodPost, path: "/films/1/force", apiKey: "admin-key", wantCode: http.StatusForbidden},
+		{name: "no auth configured on webhook", method: http.MethodPost, path: "/webhooks/jellyfin", wantCode: http.StatusOK, wantScope: c.SCOPE_READ},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotScope string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotScope = requestScope(r)
+			})
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-Api-Key", tt.apiKey)
+			}
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			authMiddleware(next, tt.auth).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got code %d, want %d", rec.Code, tt.wantCode)
+			}
+			if gotScope != tt.wantScope {
+				t.Errorf("got scope %q, want %q", gotScope, tt.wantScope)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+	"github.com/alozach/lbxd_seerr/internal/overrides"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// filmState is a watchlist film with the last decision taken for it
+type filmState struct {
+	lxbd.Film
+	Status   jellyseerr.RequestStatus `json:"status,omitempty"`
+	Details  string                   `json:"details,omitempty"`
+	Override *overrides.Override      `json:"override,omitempty"`
+	Media    *jellyseerr.TrackedMedia `json:"media,omitempty"`
+}
+
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		log.Fatalln("Failed to load dashboard files: ", err)
+	}
+	return http.FileServer(http.FS(files))
+}
+
+func getFilms(w http.ResponseWriter, r *http.Request) {
+	films, err := lxbd.GetSavedWithExpanded()
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "no watchlist data", http.StatusNotFound)
+		return
+	}
+
+	// the last requests may be missing if no run completed yet
+	requests, _ := jellyseerr.GetSavedRequests()
+
+	media := jellyseerr.GetMediaStatuses()
+
+	states := make([]filmState, 0, len(films))
+	for _, f := range films {
+		state := filmState{Film: f}
+		for _, req := range requests {
+			// expanded films whose page could not be found have no Letterboxd id either
+			if (f.Lid != 0 && req.Lid == f.Lid) || ((req.Lid == 0 || f.Lid == 0) && req.TmdbId == f.TmdbId) {
+				state.Status = req.Status
+				state.Details = req.Details
+				break
+			}
+		}
+		if o, ok := overrides.Get(f.Lid); ok {
+			state.Override = &o
+		}
+		if m, ok := media[f.MediaKey()]; ok {
+			state.Media = &m
+		}
+		states = append(states, state)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(states)
+}
+
+func overrideHandler(action overrides.Action) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lid, err := strconv.Atoi(r.PathValue("lid"))
+		if err != nil || lid <= 0 {
+			http.Error(w, "invalid film id", http.StatusBadRequest)
+			return
+		}
+
+		if err := overrides.Set(lid, action); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deleteOverride(w http.ResponseWriter, r *http.Request) {
+	lid, err := strconv.Atoi(r.PathValue("lid"))
+	if err != nil {
+		http.Error(w, "invalid film id", http.StatusBadRequest)
+		return
+	}
+
+	if err := overrides.Clear(lid); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getOverrides(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overrides.GetAll())
+}
+
+func postSync(w http.ResponseWriter, r *http.Request) {
+	if !syncRunning.TryLock() {
+		http.Error(w, "dl_watchlist is already running", http.StatusConflict)
+		return
+	}
+
+	// the lock is handed over to the run, so that no other run can start in between
+	go func() {
+		defer syncRunning.Unlock()
+		runDlWatchlist()
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
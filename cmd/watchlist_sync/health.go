@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
+	"github.com/alozach/lbxd_seerr/internal/library"
+	"github.com/alozach/lbxd_seerr/internal/runs"
+)
+
+var startTime = time.Now()
+
+type componentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+func checkLastRun() error {
+	if config.Tasks.DLWatchlist == "disabled" {
+		return nil
+	}
+
+	run := runs.LastSuccessful("dl_watchlist")
+	if run == nil {
+		// give the first run a chance to happen
+		if time.Since(startTime) > config.Server.MaxRunAge {
+			return fmt.Errorf("no successful run since startup %s ago", time.Since(startTime).Round(time.Second))
+		}
+		return nil
+	}
+
+	if age := time.Since(run.End); age > config.Server.MaxRunAge {
+		return fmt.Errorf("last successful run was %s ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+var errBrowserBusy = errors.New("browser busy")
+
+// checkBrowser does not wait for a run using the Selenium session, which may take longer than the
+// healthcheck timeout
+func checkBrowser() error {
+	if !browser.TryLock() {
+		return errBrowserBusy
+	}
+	defer browser.Unlock()
+	return scrap.CheckBrowser()
+}
+
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(componentStatus{Status: "ok"})
+}
+
+func getReadiness(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func() error{
+		"selenium": checkBrowser,
+		"tmdb":     scrap.CheckTMDb,
+		"last_run": checkLastRun,
+	}
+	checks[jellyseerr.BackendName()] = jellyseerr.CheckStatus
+	if library.Enabled() {
+		checks[library.Name()] = library.CheckStatus
+	}
+
+	res := readiness{Status: "ok", Components: map[string]componentStatus{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+			status := componentStatus{Status: "ok"}
+			if err := check(); errors.Is(err, errBrowserBusy) {
+				// the session is in use, so it was working
+				status = componentStatus{Status: "busy"}
+			} else if err != nil {
+				status = componentStatus{Status: "fail", Error: err.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Components[name] = status
+			if status.Status == "fail" {
+				res.Status = "fail"
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	// errors may tell about the network or the config, anonymous callers only get the statuses
+	if requestScope(r) == "" {
+		for name, status := range res.Components {
+			status.Error = ""
+			res.Components[name] = status
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if res.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(res)
+}
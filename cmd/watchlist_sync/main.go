@@ -6,8 +6,13 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/alozach/lbxd_seerr/internal/backend"
 	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/jellyfin"
 	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
+	"github.com/alozach/lbxd_seerr/internal/library"
+	"github.com/alozach/lbxd_seerr/internal/notify"
+	"github.com/alozach/lbxd_seerr/internal/overrides"
 	"github.com/alozach/lbxd_seerr/internal/scrapping"
 )
 
@@ -38,10 +43,17 @@ func main() {
 
 	jellyseerr.Init(config.Jellyseerr)
 	jellyseerr.AddFilters(config.Jellyseerr.Filters)
+	if config.Backend == c.BACKEND_RADARR {
+		jellyseerr.SetBackend(backend.NewRadarr(*config.Radarr))
+	}
+	library.Init(config.Library)
+	jellyfin.Init(config.Jellyfin)
+	overrides.Init(config.Overrides)
+	notify.Init(config.Notifiers)
 
-	scrap = scrapping.Init(config.TMDb.ApiKey)
+	scrap = scrapping.Init(config.Lxbd, config.TMDb)
 	defer scrapping.Deinit(scrap)
 
 	go StartScheduler()
-	go StartServer()
+	StartServer()
 }
@@ -1,33 +1,109 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
+	"github.com/alozach/lbxd_seerr/internal/metrics"
+	"github.com/alozach/lbxd_seerr/internal/overrides"
+	"github.com/alozach/lbxd_seerr/internal/runs"
 )
 
+const maxRequestsPerPage = 500
+
+func parseRequestsQuery(r *http.Request) (jellyseerr.RequestsQuery, error) {
+	params := r.URL.Query()
+	q := jellyseerr.RequestsQuery{Search: params.Get("q"), Sort: params.Get("sort"), Page: 1}
+
+	for _, statuses := range params["status"] {
+		for _, status := range strings.Split(statuses, ",") {
+			q.Statuses = append(q.Statuses, jellyseerr.RequestStatus(status))
+		}
+	}
+
+	if !jellyseerr.ValidSortField(q.Sort) {
+		return q, fmt.Errorf("invalid sort field \"%s\"", q.Sort)
+	}
+
+	var err error
+	if page := params.Get("page"); page != "" {
+		if q.Page, err = strconv.Atoi(page); err != nil || q.Page < 1 {
+			return q, errors.New("invalid page")
+		}
+	}
+	if perPage := params.Get("per_page"); perPage != "" {
+		if q.PerPage, err = strconv.Atoi(perPage); err != nil || q.PerPage < 1 || q.PerPage > maxRequestsPerPage {
+			return q, fmt.Errorf("per_page must be between 1 and %d", maxRequestsPerPage)
+		}
+	}
+	return q, nil
+}
+
 func getLastRequests(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("got /requests request\n")
+	q, err := parseRequestsQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	b, err := jellyseerr.GetLastRequets()
+	requests, total, err := jellyseerr.QueryRequests(q)
 	if err != nil {
 		log.Print(err)
+		http.Error(w, "no requests data", http.StatusNotFound)
 		return
 	}
 
-	str := string(b)
-	log.Println(str)
-	io.WriteString(w, str)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		jellyseerr.WriteRequestsCSV(w, requests)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+func getRuns(w http.ResponseWriter, r *http.Request) {
+	history, err := runs.GetRuns()
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "no run history", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
 }
 
 func StartServer() {
-	http.HandleFunc("/requests", getLastRequests)
+	http.HandleFunc("GET /requests", getLastRequests)
+	http.HandleFunc("/runs", getRuns)
+	http.HandleFunc("/metrics", metrics.Handler)
+	http.HandleFunc("/healthz", getHealth)
+	http.HandleFunc("/readyz", getReadiness)
+
+	http.Handle("/", dashboardHandler())
+	http.HandleFunc("GET /films", getFilms)
+	http.HandleFunc("POST /films/{lid}/force", overrideHandler(overrides.FORCE))
+	http.HandleFunc("POST /films/{lid}/ignore", overrideHandler(overrides.IGNORE))
+	http.HandleFunc("DELETE /films/{lid}/override", deleteOverride)
+	http.HandleFunc("GET /overrides", getOverrides)
+	http.HandleFunc("POST /webhooks/jellyseerr", postJellyseerrWebhook)
+	http.HandleFunc("POST /webhooks/jellyfin", postJellyfinWebhook)
+	http.HandleFunc("POST /runs/dl_watchlist", postSync)
+
+	if run := runs.LastSuccessful("dl_watchlist"); run != nil {
+		metrics.LastSuccessfulRun.Set(float64(run.End.Unix()), run.Task)
+	}
 
-	err := http.ListenAndServe(":3333", nil)
+	err := http.ListenAndServe(":3333", authMiddleware(http.DefaultServeMux, config.Server.Auth))
 
 	if errors.Is(err, http.ErrServerClosed) {
 		log.Printf("server closed\n")
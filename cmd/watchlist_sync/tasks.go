@@ -1,81 +1,241 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/alozach/lbxd_seerr/internal/activity"
+	"github.com/alozach/lbxd_seerr/internal/jellyfin"
 	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
 	"github.com/alozach/lbxd_seerr/internal/lxbd"
+	"github.com/alozach/lbxd_seerr/internal/metrics"
+	"github.com/alozach/lbxd_seerr/internal/notify"
+	"github.com/alozach/lbxd_seerr/internal/runs"
+	"github.com/alozach/lbxd_seerr/internal/scrapping"
 	"github.com/go-co-op/gocron/v2"
 )
 
+// syncRunning prevents a manually triggered run from overlapping a scheduled one
+var syncRunning sync.Mutex
+
+// browser is held by anything using the Selenium session, which can only do one thing at a time
+var browser sync.Mutex
+
+// lxbdLogIn reuses the saved Letterboxd session, or logs in again
+func lxbdLogIn() error {
+	if err := scrap.LxbdRestoreSession(config.Lxbd.Username, config.Lxbd.Password); err == nil {
+		return nil
+	}
+
+	if err := scrap.LxbdAcceptCookies(); err != nil {
+		return err
+	}
+
+	if err := scrap.LxbdLogIn(config.Lxbd.Username, config.Lxbd.Password); err != nil {
+		return err
+	}
+
+	scrap.LxbdSaveSession(config.Lxbd.Password)
+	return nil
+}
+
 func dlWatchlist() {
+	if !syncRunning.TryLock() {
+		log.Println("dl_watchlist job already running")
+		return
+	}
+	defer syncRunning.Unlock()
+
+	runDlWatchlist()
+}
+
+// runDlWatchlist runs the dl_watchlist job, syncRunning must be held
+func runDlWatchlist() {
+	browser.Lock()
+	defer browser.Unlock()
+
 	log.Println("Starting dl_watchlist job")
 
+	run := runs.Start("dl_watchlist")
+	var requests []jellyseerr.Request
+	defer func() {
+		finishRun(run)
+		notify.RunFinished(run, requests)
+	}()
+
+	scrap.TMDbCache.StartRun()
+	defer scrap.TMDbCache.Save()
+
 	previousData, err := lxbd.GetSavedFilms()
 	if err != nil {
 		log.Println("Failed to get previously saved data")
 	}
 
-	if err := scrap.LxbdAcceptCookies(); err != nil {
-		return
-	}
-
-	if err := scrap.LxbdLogIn(config.Lxbd.Username, config.Lxbd.Password); err != nil {
+	if err := lxbdLogIn(); err != nil {
+		failRun(run, err)
 		return
 	}
 
 	watchlist, err := getWatchlist(scrap, previousData)
 	if err != nil {
+		failRun(run, err)
 		return
 	}
 
 	log.Printf("Got %d films in watchlist", len(watchlist))
+	run.WatchlistSize = len(watchlist)
+	metrics.WatchlistSize.Set(float64(len(watchlist)))
+
+	// other films of the collections go through the same filters and limits
+	films := watchlist
+	if config.Jellyseerr.Collections {
+		previousExpanded, _ := lxbd.GetSavedExpanded()
+		expanded := scrap.ExpandCollections(watchlist, previousExpanded)
+		if scrap.UseTMDbProviders() {
+			scrap.TMDbCheckProviders(expanded)
+		}
+		log.Printf("Added %d films from TMDb collections", len(expanded))
+		films = append(films, expanded...)
+	}
+
+	// the watched list is only needed by the not_watched filter
+	if slices.Contains(config.Jellyseerr.Filters, "not_watched") {
+		if err := setWatched(scrap, films); err != nil {
+			failRun(run, err)
+			return
+		}
+	}
+
+	run.TMDbCache = scrap.TMDbCache.Stats()
+	log.Printf("TMDb cache: %s", run.TMDbCache)
 
 	jellyseerr.ResetRequestsCounter()
 
-	var requests []jellyseerr.Request
 	nbRequestsOK := 0
-	for i, f := range watchlist {
+	for i, f := range films {
 		req := jellyseerr.CreateRequest(f, (i == 0))
-		if req.Status == jellyseerr.REQ_OK {
+		if f.ExpandedFrom != nil {
+			req.Details = strings.TrimPrefix(req.Details+", "+f.ExpandedFrom.String(), ", ")
+		}
+		if req.Status == jellyseerr.REQ_OK || req.Status == jellyseerr.REQ_FORCED {
 			nbRequestsOK++
 		}
 
 		requests = append(requests, req)
+		metrics.Requests.Inc(string(req.Status))
 		log.Printf("%s (%d): %s - %s", f.TmdbInfo.Title, f.TmdbInfo.ID, req.Status, req.Details)
 	}
 
 	log.Printf("%d requests done", nbRequestsOK)
+	recordActivity(requests)
+	run.NbRequested = nbRequestsOK
 
-	lxbd.SaveFilms(watchlist)
+	// the watchlist films are the first ones, the expanded ones must not be taken for watchlist films
+	lxbd.SaveFilms(films[:len(watchlist)])
+	lxbd.SaveExpanded(films[len(watchlist):])
 	jellyseerr.SaveRequests(requests)
+	run.Succeed()
+}
+
+func recordActivity(requests []jellyseerr.Request) {
+	var requested, failed []activity.Film
+	for _, req := range requests {
+		switch req.Status {
+		case jellyseerr.REQ_OK, jellyseerr.REQ_FORCED:
+			requested = append(requested, activity.NewFilm(req))
+		case jellyseerr.REQ_BACKEND_ERROR, jellyseerr.REQ_LIBRARY_ERROR:
+			failed = append(failed, activity.NewFilm(req))
+		}
+	}
+	activity.Record(activity.ACT_REQUESTED, requested...)
+	activity.Record(activity.ACT_FAILED, failed...)
+}
+
+func failRun(run *runs.Run, err error) {
+	log.Printf("%s run failed: %s", run.Task, err)
+	run.Fail(err, scrapping.ErrorClass(err))
+	scrapping.CountError(err)
+}
+
+func finishRun(run *runs.Run) {
+	if run.Status == runs.RUN_RUNNING {
+		run.Fail(errors.New("run did not complete"), "unknown")
+	}
+
+	metrics.Runs.Inc(run.Task, string(run.Status))
+	metrics.RunDuration.Observe(run.Duration().Seconds(), run.Task)
+	if run.Status == runs.RUN_OK {
+		metrics.LastSuccessfulRun.Set(float64(run.End.Unix()), run.Task)
+	}
+
+	runs.Save(run)
+}
+
+func emailDigest() {
+	log.Println("Starting email_digest job")
+
+	if len(config.Email.To) == 0 {
+		log.Println("No email recipient configured")
+		return
+	}
+
+	if err := notify.SendDigest(config.Email); err != nil {
+		log.Println("Failed to send digest: ", err)
+	}
+}
+
+// jellyfinExport is the reverse of dlWatchlist, films watched in Jellyfin are exported for Letterboxd
+func jellyfinExport() {
+	log.Println("Starting jellyfin_export job")
+
+	if config.Jellyfin.Url == "" || config.Jellyfin.ExportUser == "" {
+		log.Println("No Jellyfin url or export user configured")
+		return
+	}
+
+	if _, err := jellyfin.ExportWatched(); err != nil {
+		log.Println("Failed to export Jellyfin watched films: ", err)
+	}
+}
+
+func addJob(sched gocron.Scheduler, name string, cron string, task func()) {
+	if cron == "disabled" {
+		log.Printf("%s task is disabled", name)
+		return
+	}
+
+	j, err := sched.NewJob(
+		gocron.CronJob(cron, false),
+		gocron.NewTask(
+			task,
+		),
+		gocron.WithName(name),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		log.Fatalln("Failed to create job: ", err)
+	}
+
+	log.Printf("Created job %s (%s)", j.Name(), j.ID())
 }
 
 func StartScheduler() {
-	location, _ := time.LoadLocation("Europe/Paris")
+	location, err := time.LoadLocation(config.Tasks.Timezone)
+	if err != nil {
+		log.Fatalln("Invalid scheduler timezone: ", err)
+	}
 	sched, err := gocron.NewScheduler(gocron.WithLocation(location))
 	if err != nil {
 		log.Fatalln("Failed to create scheduler: ", err)
 	}
 
-	if config.Tasks.DLWatchlist != "disabled" {
-		j, err := sched.NewJob(
-			gocron.CronJob(config.Tasks.DLWatchlist, false),
-			gocron.NewTask(
-				dlWatchlist,
-			),
-			gocron.WithName("dl_watchlist"),
-			gocron.WithSingletonMode(gocron.LimitModeReschedule),
-		)
-		if err != nil {
-			log.Fatalln("Failed to create job: ", err)
-		}
-
-		log.Printf("Created job %s (%s)", j.Name(), j.ID())
-	} else {
-		log.Println("dl_watchlist task is disabled")
-	}
+	addJob(sched, "dl_watchlist", config.Tasks.DLWatchlist, dlWatchlist)
+	addJob(sched, "email_digest", config.Tasks.EmailDigest, emailDigest)
+	addJob(sched, "jellyfin_export", config.Tasks.JFExport, jellyfinExport)
 
 	log.Println("Starting scheduler")
 	sched.Start()
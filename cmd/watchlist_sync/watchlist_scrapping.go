@@ -1,22 +1,39 @@
 package main
 
 import (
+	"log"
+	"slices"
+
 	"github.com/alozach/lbxd_seerr/internal/lxbd"
 	"github.com/alozach/lbxd_seerr/internal/scrapping"
 )
 
 func getWatchlist(s *scrapping.Scrapping, previousData []lxbd.Film) ([]lxbd.Film, error) {
-	films, err := s.LxbdExtractFilms("/watchlist", previousData)
+	firstSeen, err := lxbd.GetFirstSeen()
 	if err != nil {
 		return nil, err
 	}
+	films, err := s.LxbdExtractFilms("/watchlist", previousData, firstSeen)
+	if err != nil {
+		return nil, err
+	}
+	if err := firstSeen.Save(); err != nil {
+		return nil, err
+	}
+
+	if s.UseTMDbProviders() {
+		s.TMDbCheckProviders(films)
+		return films, nil
+	}
 
-	VODFilms, err := s.LxbdExtractFilms("/watchlist/on/favorite-services", films)
+	VODFilms, err := s.LxbdExtractFilms("/watchlist/on/favorite-services", films, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	for i := range films {
+		films[i].VODAvailable = false
+		films[i].VODProviders = nil
 		for _, vodf := range VODFilms {
 			if films[i].Lid == vodf.Lid {
 				films[i].VODAvailable = true
@@ -26,3 +43,25 @@ func getWatchlist(s *scrapping.Scrapping, previousData []lxbd.Film) ([]lxbd.Film
 	}
 	return films, nil
 }
+
+// setWatched flags the watchlist films which are also in the user watched list
+func setWatched(s *scrapping.Scrapping, films []lxbd.Film) error {
+	saved, err := lxbd.GetSavedWatched()
+	if err != nil {
+		log.Println("No previously saved watched films, reading the whole list")
+	}
+
+	watched, err := s.LxbdExtractWatched(saved)
+	if err != nil {
+		return err
+	}
+	// an unsaved list would be read again in full on every run
+	if err := lxbd.SaveWatched(watched); err != nil {
+		return err
+	}
+
+	for i := range films {
+		films[i].Watched = slices.Contains(watched.Lids, films[i].Lid)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+"use strict";
+
+const posterBaseUrl = "https://image.tmdb.org/t/p/w154";
+
+function showMessage(text) {
+  document.getElementById("message").textContent = text;
+}
+
+async function post(url, method = "POST") {
+  const res = await fetch(url, { method });
+  if (!res.ok) {
+    throw new Error(`${url}: ${res.status} ${await res.text()}`);
+  }
+}
+
+function renderFilms(films) {
+  const container = document.getElementById("films");
+  const template = document.getElementById("film-template");
+  container.replaceChildren();
+
+  for (const film of films) {
+    const node = template.content.cloneNode(true);
+    const info = film.tmdb_info || {};
+
+    const poster = node.querySelector(".poster");
+    if (info.poster_path) {
+      poster.src = posterBaseUrl + info.poster_path;
+    }
+    poster.alt = info.Title || "";
+
+    node.querySelector(".title").textContent = info.Title || film.link;
+    let release = info.release_date ? `Released ${info.release_date}` : "No release date";
+    if (film.added_at) {
+      release += `, added ${new Date(film.added_at).toLocaleDateString()}`;
+    }
+    node.querySelector(".release").textContent = release;
+
+    const status = node.querySelector(".status");
+    status.textContent = film.status || "not processed yet";
+    status.classList.add(film.status);
+    node.querySelector(".details").textContent = film.details || "";
+    if (film.media) {
+      node.querySelector(".media").textContent = `Jellyseerr: ${film.media.status.toLowerCase()} since ${new Date(film.media.time).toLocaleDateString()}`;
+    }
+
+    if (film.override) {
+      node.querySelector(".override").textContent = `Set to ${film.override.action} from ${film.override.source}`;
+      if (film.override.source === "api") {
+        const clear = node.querySelector(".clear");
+        clear.hidden = false;
+        clear.addEventListener("click", () => clearOverride(film.lid));
+      }
+    }
+
+    // the films expanded from a collection without a Letterboxd page can't be overridden
+    if (!film.lid) {
+      node.querySelector(".actions").hidden = true;
+    }
+    node.querySelector(".force").addEventListener("click", () => setOverride(film.lid, "force"));
+    node.querySelector(".ignore").addEventListener("click", () => setOverride(film.lid, "ignore"));
+    container.appendChild(node);
+  }
+}
+
+function renderRuns(runs) {
+  const body = document.getElementById("runs");
+  body.replaceChildren();
+
+  for (const run of runs.slice().reverse()) {
+    const row = document.createElement("tr");
+    const start = new Date(run.start);
+    const duration = run.status === "RUNNING" ? "" : `${Math.round((new Date(run.end) - start) / 1000)}s`;
+    for (const value of [run.task, start.toLocaleString(), duration, run.status, run.watchlist_size, run.nb_requested, run.error || ""]) {
+      const cell = document.createElement("td");
+      cell.textContent = value;
+      row.appendChild(cell);
+    }
+    row.children[3].classList.add("status", run.status);
+    body.appendChild(row);
+  }
+}
+
+async function refresh() {
+  try {
+    const films = await fetch("films");
+    renderFilms(films.ok ? await films.json() : []);
+
+    const runs = await fetch("runs");
+    renderRuns(runs.ok ? await runs.json() : []);
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+async function setOverride(lid, action) {
+  try {
+    await post(`films/${lid}/${action}`);
+    showMessage(`Film will be ${action === "force" ? "requested" : "ignored"} on next sync`);
+    refresh();
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+async function clearOverride(lid) {
+  try {
+    await post(`films/${lid}/override`, "DELETE");
+    showMessage("Film will go through the filters again on next sync");
+    refresh();
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+document.getElementById("sync").addEventListener("click", async () => {
+  try {
+    await post("runs/dl_watchlist");
+    showMessage("Sync started");
+  } catch (err) {
+    showMessage(err.message);
+  }
+});
+
+refresh();
+setInterval(refresh, 30000);
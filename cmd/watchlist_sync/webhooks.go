@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/activity"
+	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+	"github.com/alozach/lbxd_seerr/internal/notify"
+)
+
+// webhook endpoints are called by other services which authenticate with their own secret
+var webhookPaths = []string{"/webhooks/jellyseerr", "/webhooks/jellyfin"}
+
+// flexibleInt accepts both numbers and strings, Jellyseerr templates sending ids as strings
+type flexibleInt int
+
+func (i *flexibleInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*i = 0
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	*i = flexibleInt(v)
+	return err
+}
+
+// payload of the default Jellyseerr webhook template
+type jellyseerrWebhook struct {
+	NotificationType string `json:"notification_type"`
+	Subject          string `json:"subject"`
+	Media            *struct {
+		MediaType string      `json:"media_type"`
+		TmdbId    flexibleInt `json:"tmdbId"`
+	} `json:"media"`
+}
+
+var webhookStatuses = map[string]jellyseerr.MediaStatus{
+	"MEDIA_PENDING":       jellyseerr.MEDIA_PENDING,
+	"MEDIA_APPROVED":      jellyseerr.MEDIA_APPROVED,
+	"MEDIA_AUTO_APPROVED": jellyseerr.MEDIA_APPROVED,
+	"MEDIA_AVAILABLE":     jellyseerr.MEDIA_AVAILABLE,
+	"MEDIA_DECLINED":      jellyseerr.MEDIA_DECLINED,
+	"MEDIA_FAILED":        jellyseerr.MEDIA_FAILED,
+}
+
+// findWatchlistFilm looks for a saved watchlist film, or one expanded from its collection if expanded is set
+func findWatchlistFilm(mediaType string, tmdbId int, expanded bool) *lxbd.Film {
+	getSaved := lxbd.GetSavedFilms
+	if expanded {
+		getSaved = lxbd.GetSavedWithExpanded
+	}
+	films, err := getSaved()
+	if err != nil {
+		return nil
+	}
+	for i := range films {
+		if films[i].TmdbId == tmdbId && films[i].MediaType() == mediaType {
+			return &films[i]
+		}
+	}
+	return nil
+}
+
+func postJellyseerrWebhook(w http.ResponseWriter, r *http.Request) {
+	if config.Jellyseerr.WebhookSecret == "" {
+		http.Error(w, "Jellyseerr webhook is not configured", http.StatusNotFound)
+		return
+	}
+	if !secretsEqual(r.Header.Get("Authorization"), config.Jellyseerr.WebhookSecret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var payload jellyseerrWebhook
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Got Jellyseerr webhook %s: %s", payload.NotificationType, payload.Subject)
+
+	status, ok := webhookStatuses[payload.NotificationType]
+	if !ok || payload.Media == nil {
+		// test notifications, issues...
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	tmdbId := int(payload.Media.TmdbId)
+	// the films requested for a collection are tracked too
+	film := findWatchlistFilm(payload.Media.MediaType, tmdbId, true)
+	if film == nil {
+		log.Printf("TMDb %s %d is not in the watchlist, ignoring webhook", payload.Media.MediaType, tmdbId)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := jellyseerr.SetMediaStatus(film.MediaType(), tmdbId, status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entry := activity.NewFilm(jellyseerr.Request{Film: *film})
+	switch status {
+	case jellyseerr.MEDIA_AVAILABLE:
+		activity.Record(activity.ACT_AVAILABLE, entry)
+		notify.FilmAvailable(entry)
+	case jellyseerr.MEDIA_DECLINED, jellyseerr.MEDIA_FAILED:
+		entry.Status = string(status)
+		activity.Record(activity.ACT_FAILED, entry)
+		notify.RequestFailed(entry)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// payload expected from the Jellyfin webhook plugin, see the README for the template
+type jellyfinWebhook struct {
+	NotificationType   string      `json:"NotificationType"`
+	ItemType           string      `json:"ItemType"`
+	Name               string      `json:"Name"`
+	TmdbId             flexibleInt `json:"Provider_tmdb"`
+	PlayedToCompletion bool        `json:"PlayedToCompletion"`
+	Username           string      `json:"NotificationUsername"`
+	// the format depends on the plugin version and the server culture, see watchedAt
+	UtcTimestamp string `json:"UtcTimestamp"`
+}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.9999999",
+	"2006-01-02 15:04:05",
+	"1/2/2006 3:04:05 PM",
+	"02/01/2006 15:04:05",
+}
+
+// watchedAt returns the playback time, now if the timestamp can't be parsed
+func (p jellyfinWebhook) watchedAt() time.Time {
+	if p.UtcTimestamp != "" {
+		for _, layout := range timestampLayouts {
+			// timestamps without zone are UTC
+			if t, err := time.ParseInLocation(layout, p.UtcTimestamp, time.UTC); err == nil {
+				return t
+			}
+		}
+		log.Printf("Unknown Jellyfin timestamp format \"%s\", using current time", p.UtcTimestamp)
+	}
+	return time.Now()
+}
+
+func (p jellyfinWebhook) watched() bool {
+	switch p.NotificationType {
+	case "PlaybackStop":
+		return p.PlayedToCompletion
+	case "ItemMarkedPlayed":
+		return true
+	}
+	return false
+}
+
+// watchedFilms are the films whose Letterboxd actions were started, so that playbacks received
+// before the actions are done are not handled twice
+var watchedFilms = struct {
+	sync.Mutex
+	lids map[int]bool
+}{lids: map[int]bool{}}
+
+// claimWatched records the film as handled, returning false if it already was
+func claimWatched(lid int) bool {
+	watchedFilms.Lock()
+	defer watchedFilms.Unlock()
+
+	if watchedFilms.lids[lid] || alreadyWatched(lid) {
+		return false
+	}
+	watchedFilms.lids[lid] = true
+	return true
+}
+
+// releaseWatched allows a next playback to try again after the actions failed
+func releaseWatched(lid int) {
+	watchedFilms.Lock()
+	defer watchedFilms.Unlock()
+	delete(watchedFilms.lids, lid)
+}
+
+func alreadyWatched(lid int) bool {
+	events, err := activity.Since(time.Time{})
+	if err != nil {
+		return false
+	}
+	for _, e := range events {
+		if e.Kind == activity.ACT_WATCHED && e.Film.Lid == lid {
+			return true
+		}
+	}
+	return false
+}
+
+// markWatched applies the configured Letterboxd actions, waiting for the browser to be free
+func markWatched(film lxbd.Film, watchedAt time.Time) {
+	if err := applyWatchedActions(film, watchedAt); err != nil {
+		releaseWatched(film.Lid)
+		return
+	}
+	activity.Record(activity.ACT_WATCHED, activity.NewFilm(jellyseerr.Request{Film: film}))
+}
+
+func applyWatchedActions(film lxbd.Film, watchedAt time.Time) error {
+	browser.Lock()
+	defer browser.Unlock()
+
+	if err := lxbdLogIn(); err != nil {
+		log.Println("Failed to log in to mark film as watched: ", err)
+		return err
+	}
+
+	if config.Jellyfin.LogDiary {
+		if err := scrap.LxbdLogDiaryEntry(film, watchedAt); err != nil {
+			return err
+		}
+	} else if config.Jellyfin.MarkWatched {
+		if err := scrap.LxbdMarkWatched(film); err != nil {
+			return err
+		}
+	}
+
+	if config.Jellyfin.RemoveFromWatchlist {
+		if err := scrap.LxbdRemoveFromWatchlist(film); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func postJellyfinWebhook(w http.ResponseWriter, r *http.Request) {
+	if config.Jellyfin.WebhookSecret == "" {
+		http.Error(w, "Jellyfin webhook is not configured", http.StatusNotFound)
+		return
+	}
+	if !secretsEqual(r.Header.Get("Authorization"), config.Jellyfin.WebhookSecret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var payload jellyfinWebhook
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jf := config.Jellyfin
+	if !jf.MarkWatched && !jf.LogDiary && !jf.RemoveFromWatchlist {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if payload.ItemType != "Movie" || !payload.watched() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if len(config.Jellyfin.Users) > 0 && !slices.Contains(config.Jellyfin.Users, payload.Username) {
+		log.Printf("Ignoring playback of %s by %s", payload.Name, payload.Username)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	log.Printf("%s watched %s on Jellyfin", payload.Username, payload.Name)
+
+	// the Letterboxd actions are about the watchlist films only
+	film := findWatchlistFilm(lxbd.TMDB_MOVIE, int(payload.TmdbId), false)
+	if film == nil {
+		log.Printf("TMDb id %d is not tracked, ignoring playback", payload.TmdbId)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !claimWatched(film.Lid) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// the browser may be busy with a sync, don't keep Jellyfin waiting
+	go markWatched(*film, payload.watchedAt().Local())
+	w.WriteHeader(http.StatusAccepted)
+}
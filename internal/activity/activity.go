@@ -0,0 +1,138 @@
+package activity
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
+)
+
+type Kind string
+
+const (
+	ACT_REQUESTED Kind = "requested"
+	ACT_FAILED    Kind = "failed"
+	ACT_AVAILABLE Kind = "available"
+	ACT_WATCHED   Kind = "watched"
+)
+
+const posterBaseUrl = "https://image.tmdb.org/t/p/w342"
+const lxbdBaseUrl = "https://letterboxd.com"
+
+// Film is the description of a film shared by notifications and the activity journal
+type Film struct {
+	TmdbId    int    `json:"tmdb_id"`
+	Lid       int    `json:"lid"`
+	Title     string `json:"title"`
+	Year      int    `json:"year"`
+	PosterUrl string `json:"poster_url,omitempty"`
+	Link      string `json:"link,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Details   string `json:"details,omitempty"`
+}
+
+func NewFilm(req jellyseerr.Request) Film {
+	film := Film{
+		TmdbId:  req.Film.TmdbId,
+		Lid:     req.Film.Lid,
+		Status:  string(req.Status),
+		Details: req.Details,
+	}
+	if req.Film.LxbdEndpoint != "" {
+		film.Link = lxbdBaseUrl + req.Film.LxbdEndpoint
+	}
+	if info := req.Film.TmdbInfo; info != nil {
+		film.Title = info.Title
+		if len(info.ReleaseDate) >= 4 {
+			fmt.Sscan(info.ReleaseDate[:4], &film.Year)
+		}
+		if info.PosterPath != "" {
+			film.PosterUrl = posterBaseUrl + info.PosterPath
+		}
+	}
+	return film
+}
+
+func (f Film) String() string {
+	if f.Year > 0 {
+		return fmt.Sprintf("%s (%d)", f.Title, f.Year)
+	}
+	return f.Title
+}
+
+// Event is something that happened to a film, kept in the activity journal
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind Kind      `json:"kind"`
+	Film Film      `json:"film"`
+}
+
+// the journal is a JSON object per line, so that recording only appends to it
+const activityFilename = "/app/data/activity.jsonl"
+
+var mu sync.Mutex
+
+func Record(kind Kind, films ...Film) error {
+	if len(films) == 0 {
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(activityFilename), os.ModePerm); err != nil {
+		log.Println("Failed to record activity: ", err)
+		return err
+	}
+
+	file, err := os.OpenFile(activityFilename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println("Failed to record activity: ", err)
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	now := time.Now()
+	for _, f := range films {
+		if err := encoder.Encode(Event{Time: now, Kind: kind, Film: f}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Since returns the events recorded after t, oldest first
+func Since(t time.Time) ([]Event, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	file, err := os.Open(activityFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Println("Skipping invalid activity line: ", err)
+			continue
+		}
+		if e.Time.After(t) {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
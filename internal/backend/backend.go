@@ -0,0 +1,48 @@
+package backend
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+	"github.com/alozach/lbxd_seerr/internal/metrics"
+)
+
+// Backend is the service the films passing the filters are requested to
+type Backend interface {
+	Name() string
+	// ExistingMedia returns the media keys (see lxbd.MediaKey) of the films already requested,
+	// which must not be requested again
+	ExistingMedia() ([]string, error)
+	Request(film lxbd.Film) error
+	// HandlesSeries tells whether series can be requested, the others are left out
+	HandlesSeries() bool
+	CheckStatus() error
+}
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// APICall sends a request to an *arr like API, authenticated with an X-Api-Key header
+func APICall(api string, requestUrl string, apiKey string, method string, body io.Reader) (*http.Response, error) {
+	r, err := http.NewRequest(method, requestUrl, body)
+	if err != nil {
+		log.Printf("Failed to create %s request: %s", method, err)
+		return nil, err
+	}
+
+	r.Header.Add("Content-Type", "application/json")
+	r.Header.Add("Accept", "application/json")
+	r.Header.Add("X-Api-Key", apiKey)
+
+	start := time.Now()
+	res, err := client.Do(r)
+	if err != nil {
+		metrics.ObserveAPICall(api, start, 0)
+		log.Printf("Failed to send %s request: %s", method, err)
+		return nil, err
+	}
+	metrics.ObserveAPICall(api, start, res.StatusCode)
+	return res, nil
+}
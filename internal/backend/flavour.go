@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Jellyseerr is a fork of Overseerr, both share most of their API
+type Flavour string
+
+const (
+	FLAVOUR_JELLYSEERR Flavour = "jellyseerr"
+	FLAVOUR_OVERSEERR  Flavour = "overseerr"
+)
+
+// media server types of the Jellyseerr settings, Overseerr only works with Plex
+var mediaServerTypes = map[int]string{1: "plex", 2: "jellyfin", 3: "emby"}
+
+type serverInfo struct {
+	Flavour     Flavour
+	Version     string
+	MediaServer string
+}
+
+func (s serverInfo) String() string {
+	return fmt.Sprintf("%s %s (%s)", s.Flavour, s.Version, s.MediaServer)
+}
+
+func (b *jellyseerr) getJSON(endpoint string, out interface{}) error {
+	res, err := b.call(endpoint, http.MethodGet, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: got HTTP code %d", endpoint, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		log.Printf("Error parsing %s response: %s", endpoint, err)
+		return err
+	}
+	return nil
+}
+
+// detectServer tells Jellyseerr and Overseerr apart from the public settings,
+// which only have a media server type on Jellyseerr
+func (b *jellyseerr) detectServer() (serverInfo, error) {
+	var status struct {
+		Version string `json:"version"`
+	}
+	if err := b.getJSON("/status", &status); err != nil {
+		return serverInfo{}, err
+	}
+
+	var settings struct {
+		MediaServerType *int `json:"mediaServerType"`
+	}
+	if err := b.getJSON("/settings/public", &settings); err != nil {
+		return serverInfo{}, err
+	}
+
+	info := serverInfo{Flavour: FLAVOUR_OVERSEERR, Version: status.Version, MediaServer: "plex"}
+	if settings.MediaServerType != nil {
+		info.Flavour = FLAVOUR_JELLYSEERR
+		info.MediaServer = mediaServerTypes[*settings.MediaServerType]
+	}
+	return info, nil
+}
+
+type seerrUser struct {
+	Id               int    `json:"id"`
+	Email            string `json:"email"`
+	Username         string `json:"username"`
+	DisplayName      string `json:"displayName"`
+	PlexId           int    `json:"plexId"`
+	PlexUsername     string `json:"plexUsername"`
+	JellyfinUserId   string `json:"jellyfinUserId"`
+	JellyfinUsername string `json:"jellyfinUsername"`
+}
+
+// names a user can be configured with, the media server account fields depending on the server
+// the users sign in with: always Plex on Overseerr, the configured one on Jellyseerr
+func (u seerrUser) names(mediaServer string) []string {
+	names := []string{u.Email, u.Username, u.DisplayName}
+	if mediaServer == "plex" {
+		if u.PlexId != 0 {
+			names = append(names, strconv.Itoa(u.PlexId))
+		}
+		return append(names, u.PlexUsername)
+	}
+	return append(names, u.JellyfinUserId, u.JellyfinUsername)
+}
+
+// findUser returns the id of the user with the given name, email or media server account
+func (b *jellyseerr) findUser(server serverInfo, name string) (int, error) {
+	var users struct {
+		Results []seerrUser `json:"results"`
+	}
+	if err := b.getJSON("/user?take=1000", &users); err != nil {
+		return 0, err
+	}
+
+	for _, u := range users.Results {
+		for _, n := range u.names(server.MediaServer) {
+			if n != "" && strings.EqualFold(n, name) {
+				return u.Id, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no %s user named %s", server.Flavour, name)
+}
+
+type seerrRequest struct {
+	Is4K  bool `json:"is4k"`
+	Media struct {
+		MediaType string `json:"mediaType"`
+		TmdbId    int    `json:"tmdbId"`
+	} `json:"media"`
+}
+
+// getAllRequests goes through every page of requests, Overseerr and Jellyseerr only return 10 by default
+func (b *jellyseerr) getAllRequests() ([]seerrRequest, error) {
+	const pageSize = 100
+
+	var requests []seerrRequest
+	for skip := 0; ; skip += pageSize {
+		var page struct {
+			PageInfo struct {
+				Results int `json:"results"`
+			} `json:"pageInfo"`
+			Results []seerrRequest `json:"results"`
+		}
+		q := url.Values{"take": {strconv.Itoa(pageSize)}, "skip": {strconv.Itoa(skip)}}
+		if err := b.getJSON("/request?"+q.Encode(), &page); err != nil {
+			log.Println("Error getting requests: ", err)
+			return nil, err
+		}
+
+		requests = append(requests, page.Results...)
+		if len(page.Results) < pageSize || len(requests) >= page.PageInfo.Results {
+			return requests, nil
+		}
+	}
+}
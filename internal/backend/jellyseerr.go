@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// jellyseerr requests films to Jellyseerr, or to Overseerr which shares most of its API
+type jellyseerr struct {
+	url       string
+	apiKey    string
+	user      string
+	is4K      bool
+	tvSeasons string
+	server    *serverInfo
+	userId    int
+}
+
+func NewJellyseerr(config c.JellyseerrConfig) Backend {
+	return &jellyseerr{
+		url:       config.BaseUrl + "/api/v1",
+		apiKey:    config.ApiKey,
+		user:      config.User,
+		is4K:      config.Is4K,
+		tvSeasons: config.TVSeasons,
+	}
+}
+
+func (b *jellyseerr) call(endpoint string, method string, body io.Reader) (*http.Response, error) {
+	return APICall("jellyseerr", b.url+endpoint, b.apiKey, method, body)
+}
+
+// legacy requester, from when the user was not configurable
+const defaultUserId = 2
+
+// detect gets the server flavour and the requesting user on first use
+func (b *jellyseerr) detect() error {
+	if b.server != nil {
+		return nil
+	}
+
+	info, err := b.detectServer()
+	if err != nil {
+		return err
+	}
+	log.Printf("Connected to %s", info)
+
+	b.userId = defaultUserId
+	if b.user != "" {
+		if b.userId, err = b.findUser(info, b.user); err != nil {
+			return err
+		}
+	}
+
+	b.server = &info
+	return nil
+}
+
+func (b *jellyseerr) Name() string {
+	if b.server != nil {
+		return string(b.server.Flavour)
+	}
+	return string(FLAVOUR_JELLYSEERR)
+}
+
+func (b *jellyseerr) ExistingMedia() ([]string, error) {
+	if err := b.detect(); err != nil {
+		return nil, err
+	}
+
+	requests, err := b.getAllRequests()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, r := range requests {
+		// a 4K request does not prevent a standard one, and the other way around
+		if r.Is4K == b.is4K {
+			keys = append(keys, lxbd.MediaKey(r.Media.MediaType, r.Media.TmdbId))
+		}
+	}
+	return keys, nil
+}
+
+func (b *jellyseerr) HandlesSeries() bool {
+	return true
+}
+
+func (b *jellyseerr) Request(film lxbd.Film) error {
+	if err := b.detect(); err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{"mediaType": film.MediaType(), "mediaId": film.TmdbId, "userId": b.userId, "is4k": b.is4K}
+	if film.IsTV() {
+		body["seasons"] = b.selectSeasons(film)
+	}
+	data, _ := json.Marshal(body)
+
+	res, err := b.call("/request", http.MethodPost, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Got HTTP code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// selectSeasons returns the seasons of a series to request, "all" also requesting the future ones
+func (b *jellyseerr) selectSeasons(film lxbd.Film) interface{} {
+	if len(film.Seasons) == 0 {
+		return "all"
+	}
+
+	switch b.tvSeasons {
+	case "first":
+		first := slices.MinFunc(film.Seasons, func(a, b lxbd.Season) int { return a.Number - b.Number })
+		return []int{first.Number}
+	case "latest":
+		// announced seasons are not available yet
+		today := time.Now().Format("2006-01-02")
+		latest := 0
+		for _, season := range film.Seasons {
+			if season.AirDate != "" && season.AirDate <= today {
+				latest = max(latest, season.Number)
+			}
+		}
+		if latest > 0 {
+			return []int{latest}
+		}
+	}
+	return "all"
+}
+
+func (b *jellyseerr) CheckStatus() error {
+	for _, endpoint := range []string{"/status", "/auth/me"} {
+		res, err := b.call(endpoint, http.MethodGet, nil)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: got HTTP code %d", endpoint, res.StatusCode)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+var testUsers = []map[string]interface{}{
+	{"id": 1, "email": "admin@example.com", "username": "admin", "plexId": 1234, "plexUsername": "plexadmin"},
+	{"id": 3, "email": "jane@example.com", "displayName": "Jane", "plexId": 5678, "plexUsername": "janeplex"},
+	{"id": 4, "email": "john@example.com", "jellyfinUserId": "a1b2c3", "jellyfinUsername": "johnjf"},
+}
+
+// fakeSeerr stands in for Overseerr when mediaServerType is nil, for Jellyseerr otherwise, holding
+// requestCount 4K requests then standard ones, and recording the created requests
+func fakeSeerr(t *testing.T, mediaServerType *int, requestCount int) (*httptest.Server, *[]map[string]interface{}) {
+	var created []map[string]interface{}
+	mux := http.NewServeMux()
+	reply := func(w http.ResponseWriter, v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+
+	mux.HandleFunc("GET /api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]string{"version": "1.9.2"})
+	})
+	mux.HandleFunc("GET /api/v1/settings/public", func(w http.ResponseWriter, r *http.Request) {
+		settings := map[string]interface{}{"applicationTitle": "Seerr"}
+		if mediaServerType != nil {
+			settings["mediaServerType"] = *mediaServerType
+		}
+		reply(w, settings)
+	})
+	mux.HandleFunc("GET /api/v1/auth/me", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, testUsers[0])
+	})
+	mux.HandleFunc("GET /api/v1/user", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{"results": testUsers})
+	})
+	mux.HandleFunc("GET /api/v1/request", func(w http.ResponseWriter, r *http.Request) {
+		take, _ := strconv.Atoi(r.URL.Query().Get("take"))
+		skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+		results := []map[string]interface{}{}
+		for i := skip; i < min(skip+take, requestCount); i++ {
+			media := map[string]interface{}{"mediaType": "movie", "tmdbId": i + 1}
+			if i%10 == 0 {
+				media = map[string]interface{}{"mediaType": "tv", "tmdbId": i + 1}
+			}
+			results = append(results, map[string]interface{}{"is4k": i < 2, "media": media})
+		}
+		reply(w, map[string]interface{}{"pageInfo": map[string]int{"results": requestCount}, "results": results})
+	})
+	mux.HandleFunc("POST /api/v1/request", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		created = append(created, body)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &created
+}
+
+func TestDetect(t *testing.T) {
+	jellyfin, plex := 2, 1
+	tests := []struct {
+		name            string
+		mediaServerType *int
+		user            string
+		flavour         Flavour
+		mediaServer     string
+		userId          int
+		wantErr         bool
+	}{
+		{name: "overseerr default user", flavour: FLAVOUR_OVERSEERR, mediaServer: "plex", userId: defaultUserId},
+		{name: "overseerr plex username", user: "JanePlex", flavour: FLAVOUR_OVERSEERR, mediaServer: "plex", userId: 3},
+		{name: "overseerr plex id", user: "1234", flavour: FLAVOUR_OVERSEERR, mediaServer: "plex", userId: 1},
+		{name: "overseerr email", user: "jane@example.com", flavour: FLAVOUR_OVERSEERR, mediaServer: "plex", userId: 3},
+		{name: "overseerr ignores jellyfin username", user: "johnjf", wantErr: true},
+		{name: "jellyseerr jellyfin username", mediaServerType: &jellyfin, user: "johnjf", flavour: FLAVOUR_JELLYSEERR, mediaServer: "jellyfin", userId: 4},
+		{name: "jellyseerr jellyfin id", mediaServerType: &jellyfin, user: "a1b2c3", flavour: FLAVOUR_JELLYSEERR, mediaServer: "jellyfin", userId: 4},
+		{name: "jellyseerr display name", mediaServerType: &jellyfin, user: "jane", flavour: FLAVOUR_JELLYSEERR, mediaServer: "jellyfin", userId: 3},
+		{name: "jellyseerr ignores plex username", mediaServerType: &jellyfin, user: "janeplex", wantErr: true},
+		{name: "jellyseerr on plex", mediaServerType: &plex, user: "plexadmin", flavour: FLAVOUR_JELLYSEERR, mediaServer: "plex", userId: 1},
+		{name: "unknown user", mediaServerType: &jellyfin, user: "nobody", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := fakeSeerr(t, tt.mediaServerType, 0)
+			b := NewJellyseerr(c.JellyseerrConfig{BaseUrl: srv.URL, ApiKey: "key", User: tt.user}).(*jellyseerr)
+
+			err := b.detect()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got user %d, want an error", b.userId)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.server.Flavour != tt.flavour || b.server.MediaServer != tt.mediaServer {
+				t.Errorf("got %s", b.server)
+			}
+			if b.Name() != string(tt.flavour) {
+				t.Errorf("got name %s", b.Name())
+			}
+			if b.userId != tt.userId {
+				t.Errorf("got user %d, want %d", b.userId, tt.userId)
+			}
+		})
+	}
+}
+
+func TestExistingMedia(t *testing.T) {
+	for _, is4K := range []bool{false, true} {
+		srv, _ := fakeSeerr(t, nil, 250)
+		b := NewJellyseerr(c.JellyseerrConfig{BaseUrl: srv.URL, ApiKey: "key", Is4K: is4K})
+
+		keys, err := b.ExistingMedia()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the first 2 requests are 4K ones, the first one a series
+		want := 248
+		if is4K {
+			want = 2
+		}
+		if len(keys) != want {
+			t.Errorf("4K %t: got %d keys, want %d", is4K, len(keys), want)
+		}
+		if is4K && !slices.Equal(keys, []string{"tv/1", "2"}) {
+			t.Errorf("got %v", keys)
+		}
+		if !is4K && !slices.Contains(keys, "250") {
+			t.Errorf("the last page was not read")
+		}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	movie := lxbd.Film{TmdbId: 603}
+	series := lxbd.Film{TmdbId: 1399, TmdbType: lxbd.TMDB_TV, Seasons: []lxbd.Season{
+		{Number: 2, AirDate: "2012-04-01"},
+		{Number: 1, AirDate: "2011-04-17"},
+		{Number: 3, AirDate: "2099-01-01"},
+		{Number: 4},
+	}}
+
+	tests := []struct {
+		name      string
+		film      lxbd.Film
+		tvSeasons string
+		check     func(t *testing.T, body map[string]interface{})
+	}{
+		{
+			name: "movie",
+			film: movie,
+			check: func(t *testing.T, body map[string]interface{}) {
+				if body["mediaType"] != "movie" || body["mediaId"] != 603.0 || body["userId"] != 3.0 || body["is4k"] != true {
+					t.Errorf("got %v", body)
+				}
+				if _, ok := body["seasons"]; ok {
+					t.Errorf("got seasons for a movie")
+				}
+			},
+		},
+		{
+			name:      "all seasons",
+			film:      series,
+			tvSeasons: "all",
+			check: func(t *testing.T, body map[string]interface{}) {
+				if body["mediaType"] != "tv" || body["seasons"] != "all" {
+					t.Errorf("got %v", body)
+				}
+			},
+		},
+		{
+			name:      "first season",
+			film:      series,
+			tvSeasons: "first",
+			check: func(t *testing.T, body map[string]interface{}) {
+				if seasons, _ := body["seasons"].([]interface{}); len(seasons) != 1 || seasons[0] != 1.0 {
+					t.Errorf("got %v", body)
+				}
+			},
+		},
+		{
+			name:      "latest aired season",
+			film:      series,
+			tvSeasons: "latest",
+			check: func(t *testing.T, body map[string]interface{}) {
+				if seasons, _ := body["seasons"].([]interface{}); len(seasons) != 1 || seasons[0] != 2.0 {
+					t.Errorf("got %v", body)
+				}
+			},
+		},
+		{
+			name:      "latest without aired season",
+			film:      lxbd.Film{TmdbId: 1400, TmdbType: lxbd.TMDB_TV, Seasons: []lxbd.Season{{Number: 1, AirDate: "2099-01-01"}}},
+			tvSeasons: "latest",
+			check: func(t *testing.T, body map[string]interface{}) {
+				if body["seasons"] != "all" {
+					t.Errorf("got %v", body)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, created := fakeSeerr(t, nil, 0)
+			b := NewJellyseerr(c.JellyseerrConfig{BaseUrl: srv.URL, ApiKey: "key", User: "janeplex", Is4K: true, TVSeasons: tt.tvSeasons})
+
+			if err := b.Request(tt.film); err != nil {
+				t.Fatal(err)
+			}
+			if len(*created) != 1 {
+				t.Fatalf("got %d requests", len(*created))
+			}
+			tt.check(t, (*created)[0])
+		})
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	srv, _ := fakeSeerr(t, nil, 0)
+	if err := NewJellyseerr(c.JellyseerrConfig{BaseUrl: srv.URL, ApiKey: "key"}).CheckStatus(); err != nil {
+		t.Error(err)
+	}
+	if err := NewJellyseerr(c.JellyseerrConfig{BaseUrl: srv.URL, ApiKey: "wrong"}).CheckStatus(); err == nil {
+		t.Error("a wrong API key passed")
+	}
+}
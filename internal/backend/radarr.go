@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// radarr adds the films directly to a Radarr v3 instance
+type radarr struct {
+	config c.RadarrConfig
+	url    string
+	tagIds []int
+}
+
+type radarrTag struct {
+	Id    int    `json:"id,omitempty"`
+	Label string `json:"label"`
+}
+
+func NewRadarr(config c.RadarrConfig) Backend {
+	if config.MinimumAvailability == "" {
+		config.MinimumAvailability = "released"
+	}
+	return &radarr{config: config, url: strings.TrimSuffix(config.BaseUrl, "/") + "/api/v3"}
+}
+
+func (r *radarr) Name() string {
+	return "radarr"
+}
+
+// call sends a request to the Radarr API and decodes the response into out if not nil
+func (r *radarr) call(endpoint string, method string, in interface{}, expectedCode int, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(data)
+	}
+
+	res, err := APICall("radarr", r.url+endpoint, r.config.ApiKey, method, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != expectedCode {
+		// Radarr explains validation failures in the body
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s: got HTTP code %d %s", endpoint, res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		log.Printf("Error parsing Radarr %s response: %s", endpoint, err)
+		return err
+	}
+	return nil
+}
+
+// ExistingMedia returns every film already in Radarr, downloaded or not
+func (r *radarr) ExistingMedia() ([]string, error) {
+	var movies []struct {
+		TmdbId int `json:"tmdbId"`
+	}
+	if err := r.call("/movie", http.MethodGet, nil, http.StatusOK, &movies); err != nil {
+		log.Println("Error getting Radarr movies: ", err)
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(movies))
+	for _, m := range movies {
+		keys = append(keys, lxbd.MediaKey(lxbd.TMDB_MOVIE, m.TmdbId))
+	}
+	return keys, nil
+}
+
+// resolveTags gets the ids of the configured tags, creating the missing ones
+func (r *radarr) resolveTags() ([]int, error) {
+	// Radarr rejects null tags
+	if len(r.config.Tags) == 0 {
+		return []int{}, nil
+	}
+	if r.tagIds != nil {
+		return r.tagIds, nil
+	}
+
+	var tags []radarrTag
+	if err := r.call("/tag", http.MethodGet, nil, http.StatusOK, &tags); err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	for _, label := range r.config.Tags {
+		found := false
+		for _, t := range tags {
+			if strings.EqualFold(t.Label, label) {
+				ids = append(ids, t.Id)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		var created radarrTag
+		if err := r.call("/tag", http.MethodPost, radarrTag{Label: label}, http.StatusCreated, &created); err != nil {
+			return nil, err
+		}
+		log.Printf("Created Radarr tag %s", label)
+		ids = append(ids, created.Id)
+	}
+
+	r.tagIds = ids
+	return ids, nil
+}
+
+func (r *radarr) HandlesSeries() bool {
+	return false
+}
+
+func (r *radarr) Request(film lxbd.Film) error {
+	if film.IsTV() {
+		return errors.New("Radarr does not handle series")
+	}
+
+	tagIds, err := r.resolveTags()
+	if err != nil {
+		return err
+	}
+
+	// the lookup result is the movie resource Radarr expects to be posted back
+	var movie map[string]interface{}
+	if err := r.call("/movie/lookup/tmdb?tmdbId="+strconv.Itoa(film.TmdbId), http.MethodGet, nil, http.StatusOK, &movie); err != nil {
+		return err
+	}
+
+	movie["qualityProfileId"] = r.config.QualityProfileId
+	movie["rootFolderPath"] = r.config.RootFolder
+	movie["minimumAvailability"] = r.config.MinimumAvailability
+	movie["monitored"] = true
+	movie["tags"] = tagIds
+	movie["addOptions"] = map[string]interface{}{"searchForMovie": r.config.SearchForMovie}
+
+	return r.call("/movie", http.MethodPost, movie, http.StatusCreated, nil)
+}
+
+func (r *radarr) CheckStatus() error {
+	return r.call("/system/status", http.MethodGet, nil, http.StatusOK, nil)
+}
@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// fakeRadarr holds a "4k" tag and records the added movies and created tags
+func fakeRadarr(t *testing.T) (*httptest.Server, *[]map[string]interface{}, *[]string) {
+	var added []map[string]interface{}
+	var createdTags []string
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/v3/movie", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]int{{"tmdbId": 603}})
+	})
+	mux.HandleFunc("GET /api/v3/movie/lookup/tmdb", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"title": "The Matrix", "tmdbId": r.URL.Query().Get("tmdbId"), "tags": []int{}})
+	})
+	mux.HandleFunc("POST /api/v3/movie", func(w http.ResponseWriter, r *http.Request) {
+		var movie map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&movie)
+		added = append(added, movie)
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /api/v3/tag", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]radarrTag{{Id: 1, Label: "4k"}})
+	})
+	mux.HandleFunc("POST /api/v3/tag", func(w http.ResponseWriter, r *http.Request) {
+		var tag radarrTag
+		json.NewDecoder(r.Body).Decode(&tag)
+		createdTags = append(createdTags, tag.Label)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(radarrTag{Id: 2, Label: tag.Label})
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &added, &createdTags
+}
+
+func TestRadarrRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		tags        []string
+		wantTags    []interface{}
+		createdTags []string
+	}{
+		{name: "no tags", wantTags: []interface{}{}},
+		{name: "existing tag", tags: []string{"4K"}, wantTags: []interface{}{1.0}},
+		{name: "new tag", tags: []string{"4k", "lbxd"}, wantTags: []interface{}{1.0, 2.0}, createdTags: []string{"lbxd"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, added, createdTags := fakeRadarr(t)
+			b := NewRadarr(c.RadarrConfig{BaseUrl: srv.URL + "/", ApiKey: "key", QualityProfileId: 4, RootFolder: "/movies", Tags: tt.tags})
+
+			if err := b.Request(lxbd.Film{TmdbId: 604}); err != nil {
+				t.Fatal(err)
+			}
+			if len(*added) != 1 {
+				t.Fatalf("got %d movies added", len(*added))
+			}
+			movie := (*added)[0]
+			tags, ok := movie["tags"].([]interface{})
+			if !ok || !slices.Equal(tags, tt.wantTags) {
+				t.Errorf("got tags %v, want %v", movie["tags"], tt.wantTags)
+			}
+			if movie["qualityProfileId"] != 4.0 || movie["rootFolderPath"] != "/movies" || movie["minimumAvailability"] != "released" || movie["title"] != "The Matrix" {
+				t.Errorf("got %v", movie)
+			}
+			if !slices.Equal(*createdTags, tt.createdTags) {
+				t.Errorf("created tags %v, want %v", *createdTags, tt.createdTags)
+			}
+		})
+	}
+}
+
+func TestRadarrExistingMedia(t *testing.T) {
+	srv, _, _ := fakeRadarr(t)
+	b := NewRadarr(c.RadarrConfig{BaseUrl: srv.URL, ApiKey: "key"})
+
+	if b.HandlesSeries() {
+		t.Error("Radarr handles series")
+	}
+	keys, err := b.ExistingMedia()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys, []string{"603"}) {
+		t.Errorf("got %v", keys)
+	}
+	if err := NewRadarr(c.RadarrConfig{BaseUrl: srv.URL, ApiKey: "wrong"}).CheckStatus(); err == nil {
+		t.Error("a wrong API key passed")
+	}
+}
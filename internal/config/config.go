@@ -2,6 +2,8 @@ package config
 
 import (
 	"log"
+	"sync"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
@@ -9,38 +11,172 @@ import (
 
 type Configuration struct {
 	Lxbd       LxbdConfig
+	Backend    string `mapstructure:"backend" validate:"oneof=jellyseerr radarr"`
 	Jellyseerr JellyseerrConfig
+	Radarr     *RadarrConfig `validate:"required_if=Backend radarr"`
 	TMDb       TMDbConfig
 	Tasks      TasksConfig
+	Server     ServerConfig
+	Overrides  OverridesConfig
+	Notifiers  []NotifierConfig `mapstructure:"notifications" validate:"dive"`
+	Email      EmailConfig
+	Jellyfin   JellyfinConfig
+	Library    LibraryConfig
 }
 
 type LxbdConfig struct {
-	Username string `validate:"required"`
-	Password string `validate:"required"`
+	Username        string        `validate:"required"`
+	Password        string        `validate:"required"`
+	PageTimeout     time.Duration `mapstructure:"page_timeout"`
+	ElementTimeout  time.Duration `mapstructure:"element_timeout"`
+	Retries         int           `mapstructure:"retries" validate:"min=1"`
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	Concurrency     int           `mapstructure:"concurrency" validate:"min=1"`
+	RequestDelay    time.Duration `mapstructure:"request_delay"`
+	RatingsTTL      time.Duration `mapstructure:"ratings_ttl"`
+	WatchedFullRead time.Duration `mapstructure:"watched_full_read"`
 }
 
 type JellyseerrConfig struct {
-	ApiKey        string   `mapstructure:"api_key" validate:"required"`
-	BaseUrl       string   `mapstructure:"base_url" validate:"required"`
-	RequestsLimit int      `mapstructure:"requests_limit"`
-	Filters       []string `mapstructure:"filters"`
+	ApiKey           string        `mapstructure:"api_key"`
+	BaseUrl          string        `mapstructure:"base_url"`
+	RequestsLimit    int           `mapstructure:"requests_limit"`
+	Filters          []string      `mapstructure:"filters"`
+	WebhookSecret    string        `mapstructure:"webhook_secret"`
+	MinRating        float64       `mapstructure:"lbxd_min_rating" validate:"min=0,max=5"`
+	MinRatings       int           `mapstructure:"lbxd_min_ratings_count" validate:"min=0"`
+	MinFriendsRating float64       `mapstructure:"lbxd_min_friends_rating" validate:"min=0,max=5"`
+	User             string        `mapstructure:"user"`
+	Is4K             bool          `mapstructure:"4k"`
+	TVSeasons        string        `mapstructure:"tv_seasons" validate:"oneof=all first latest"`
+	Collections      bool          `mapstructure:"expand_collections"`
+	MinAge           time.Duration `mapstructure:"min_watchlist_age"`
+	MaxAge           time.Duration `mapstructure:"max_watchlist_age"`
+}
+
+const (
+	BACKEND_JELLYSEERR = "jellyseerr"
+	BACKEND_RADARR     = "radarr"
+)
+
+type RadarrConfig struct {
+	ApiKey              string   `mapstructure:"api_key" validate:"required"`
+	BaseUrl             string   `mapstructure:"base_url" validate:"required"`
+	QualityProfileId    int      `mapstructure:"quality_profile_id" validate:"min=1"`
+	RootFolder          string   `mapstructure:"root_folder" validate:"required"`
+	Tags                []string `mapstructure:"tags"`
+	MinimumAvailability string   `mapstructure:"minimum_availability" validate:"omitempty,oneof=announced inCinemas released"`
+	SearchForMovie      bool     `mapstructure:"search_for_movie"`
 }
 
 type TMDbConfig struct {
-	ApiKey string `mapstructure:"api_key" validate:"required"`
+	ApiKey       string              `mapstructure:"api_key" validate:"required"`
+	Language     string              `mapstructure:"language"`
+	Regions      []string            `mapstructure:"region" validate:"min=1"`
+	ReleaseTypes []string            `mapstructure:"release_types" validate:"min=1"`
+	Providers    TMDbProvidersConfig `mapstructure:"watch_providers"`
+	Cache        TMDbCacheConfig     `mapstructure:"cache"`
+}
+
+type TMDbProvidersConfig struct {
+	Region string   `mapstructure:"region"`
+	Ids    []int    `mapstructure:"ids"`
+	Types  []string `mapstructure:"types" validate:"dive,oneof=flatrate free ads rent buy"`
+}
+
+type TMDbCacheConfig struct {
+	ReleaseDateTTL time.Duration `mapstructure:"release_date_ttl"`
+	RevenueTTL     time.Duration `mapstructure:"revenue_ttl"`
+	ForceRefresh   bool          `mapstructure:"force_refresh"`
+	EvictAfter     time.Duration `mapstructure:"evict_after"`
 }
 
 type TasksConfig struct {
+	Timezone    string `mapstructure:"timezone"`
 	DLWatchlist string `mapstructure:"dl_watchlist"`
+	EmailDigest string `mapstructure:"email_digest"`
+	JFExport    string `mapstructure:"jellyfin_export"`
+}
+
+type NotifierConfig struct {
+	Type     string   `mapstructure:"type" validate:"oneof=webhook discord ntfy gotify"`
+	Url      string   `mapstructure:"url" validate:"required,url"`
+	Token    string   `mapstructure:"token"`
+	Template string   `mapstructure:"template"`
+	Events   []string `mapstructure:"events" validate:"min=1,dive,oneof=new_request error film_available limit_reached"`
+}
+
+type JellyfinConfig struct {
+	Url                 string   `mapstructure:"url"`
+	ApiKey              string   `mapstructure:"api_key"`
+	ExportUser          string   `mapstructure:"export_user"`
+	WebhookSecret       string   `mapstructure:"webhook_secret"`
+	Users               []string `mapstructure:"users"`
+	MarkWatched         bool     `mapstructure:"mark_watched"`
+	LogDiary            bool     `mapstructure:"log_diary"`
+	RemoveFromWatchlist bool     `mapstructure:"remove_from_watchlist"`
+}
+
+// LibraryConfig is the media server checked for films already in the library
+type LibraryConfig struct {
+	Type  string `mapstructure:"type" validate:"omitempty,oneof=jellyfin plex"`
+	Url   string `mapstructure:"url" validate:"required_with=Type"`
+	Token string `mapstructure:"token" validate:"required_with=Type"`
+}
+
+type EmailConfig struct {
+	SmtpHost    string   `mapstructure:"smtp_host" validate:"required_with=To"`
+	SmtpPort    int      `mapstructure:"smtp_port"`
+	Username    string   `mapstructure:"username"`
+	Password    string   `mapstructure:"password"`
+	ImplicitTLS bool     `mapstructure:"implicit_tls"`
+	From        string   `mapstructure:"from" validate:"required_with=To"`
+	To          []string `mapstructure:"to" validate:"dive,email"`
+}
+
+// OverridesConfig lists Letterboxd film ids to always request or never request
+type OverridesConfig struct {
+	Force  []int `mapstructure:"force"`
+	Ignore []int `mapstructure:"ignore"`
+}
+
+type ServerConfig struct {
+	MaxRunAge time.Duration `mapstructure:"max_run_age"`
+	Auth      AuthConfig    `mapstructure:"auth"`
+}
+
+const (
+	SCOPE_READ  = "read"
+	SCOPE_ADMIN = "admin"
+)
+
+type AuthConfig struct {
+	ApiKeys []ApiKeyConfig `mapstructure:"api_keys" validate:"dive"`
+	Users   []UserConfig   `mapstructure:"users" validate:"dive"`
+}
+
+type ApiKeyConfig struct {
+	Key   string `mapstructure:"key" validate:"required"`
+	Scope string `mapstructure:"scope" validate:"oneof=read admin"`
+}
+
+type UserConfig struct {
+	Username string `mapstructure:"username" validate:"required"`
+	Password string `mapstructure:"password" validate:"required"`
+	Scope    string `mapstructure:"scope" validate:"oneof=read admin"`
 }
 
 var config Configuration
+var loadOnce sync.Once
 
+// GetConfig reads the config file on first call, so that packages using the config types can be
+// tested without one
 func GetConfig() *Configuration {
+	loadOnce.Do(load)
 	return &config
 }
 
-func init() {
+func load() {
 	viper.AddConfigPath("/config")
 	viper.SetConfigName("lbxd_seerr")
 	viper.SetConfigType("yml")
@@ -49,8 +185,30 @@ func init() {
 		log.Fatalf("Error reading config file, %s", err)
 	}
 
+	viper.SetDefault("lxbd.page_timeout", 30*time.Second)
+	viper.SetDefault("lxbd.element_timeout", 10*time.Second)
+	viper.SetDefault("lxbd.retries", 3)
+	viper.SetDefault("lxbd.retry_backoff", 5*time.Second)
+	viper.SetDefault("lxbd.concurrency", 6)
+	viper.SetDefault("lxbd.request_delay", 200*time.Millisecond)
+	viper.SetDefault("lxbd.ratings_ttl", 7*24*time.Hour)
+	viper.SetDefault("lxbd.watched_full_read", 7*24*time.Hour)
+	viper.SetDefault("tmdb.language", "fr-FR")
+	viper.SetDefault("tmdb.region", []string{"FR"})
+	viper.SetDefault("tmdb.release_types", []string{"theatrical_limited", "theatrical"})
+	viper.SetDefault("tmdb.watch_providers.types", []string{"flatrate"})
+	viper.SetDefault("tmdb.cache.release_date_ttl", 24*time.Hour)
+	viper.SetDefault("tmdb.cache.revenue_ttl", 7*24*time.Hour)
+	viper.SetDefault("tmdb.cache.evict_after", 30*24*time.Hour)
+	viper.SetDefault("backend", BACKEND_JELLYSEERR)
 	viper.SetDefault("jellyseerr.requests_limit", -1)
+	viper.SetDefault("jellyseerr.tv_seasons", "all")
+	viper.SetDefault("tasks.timezone", "Europe/Paris")
 	viper.SetDefault("tasks.dl_watchlist", "disabled")
+	viper.SetDefault("tasks.email_digest", "disabled")
+	viper.SetDefault("tasks.jellyfin_export", "disabled")
+	viper.SetDefault("email.smtp_port", 587)
+	viper.SetDefault("server.max_run_age", 48*time.Hour)
 
 	err := viper.Unmarshal(&config)
 	if err != nil {
@@ -61,4 +219,9 @@ func init() {
 	if err := validate.Struct(&config); err != nil {
 		log.Fatalf("Missing required attributes %v\n", err)
 	}
+
+	// filters and limits stay in the jellyseerr section whatever the backend
+	if config.Backend == BACKEND_JELLYSEERR && (config.Jellyseerr.ApiKey == "" || config.Jellyseerr.BaseUrl == "") {
+		log.Fatalln("Missing required attributes jellyseerr.api_key and jellyseerr.base_url")
+	}
 }
@@ -0,0 +1,122 @@
+package jellyfin
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const exportDir = "/app/data/letterboxd_export"
+
+// exportedFilename keeps the entries already exported, one "tmdbId date" per line
+const exportedFilename = "/app/data/jellyfin_exported.txt"
+
+const dateFormat = "2006-01-02"
+
+// a film watched again on another day is a new diary entry
+func entryKey(f PlayedFilm) string {
+	date := ""
+	if !f.WatchedDate.IsZero() {
+		date = f.WatchedDate.Local().Format(dateFormat)
+	}
+	return fmt.Sprintf("%d %s", f.TmdbId, date)
+}
+
+func loadExported() map[string]bool {
+	exported := map[string]bool{}
+
+	file, err := os.Open(exportedFilename)
+	if err != nil {
+		return exported
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		exported[scanner.Text()] = true
+	}
+	return exported
+}
+
+func saveExported(films []PlayedFilm) error {
+	file, err := os.OpenFile(exportedFilename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, f := range films {
+		if _, err := fmt.Fprintln(file, entryKey(f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(filename string, films []PlayedFilm) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"tmdbID", "Title", "Year", "WatchedDate", "Rating10"})
+	for _, f := range films {
+		year, date, rating := "", "", ""
+		if f.Year > 0 {
+			year = strconv.Itoa(f.Year)
+		}
+		if !f.WatchedDate.IsZero() {
+			date = f.WatchedDate.Local().Format(dateFormat)
+		}
+		if f.Rating10 > 0 {
+			rating = strconv.Itoa(f.Rating10)
+		}
+		w.Write([]string{strconv.Itoa(f.TmdbId), f.Title, year, date, rating})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ExportWatched writes the films played since the previous export to a CSV file
+// which can be imported in Letterboxd, and returns its path
+func ExportWatched() (string, error) {
+	films, err := GetPlayedFilms()
+	if err != nil {
+		return "", err
+	}
+
+	exported := loadExported()
+	var newFilms []PlayedFilm
+	for _, f := range films {
+		if !exported[entryKey(f)] {
+			newFilms = append(newFilms, f)
+		}
+	}
+
+	if len(newFilms) == 0 {
+		log.Println("No new watched film to export")
+		return "", nil
+	}
+
+	if err := os.MkdirAll(exportDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	filename := filepath.Join(exportDir, "letterboxd_"+time.Now().Format("2006_01_02_15_04_05")+".csv")
+	if err := writeCSV(filename, newFilms); err != nil {
+		return "", err
+	}
+	if err := saveExported(newFilms); err != nil {
+		log.Println("Failed to save exported films: ", err)
+		return filename, err
+	}
+
+	log.Printf("Exported %d watched films to %s", len(newFilms), filename)
+	return filename, nil
+}
@@ -0,0 +1,143 @@
+package jellyfin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/metrics"
+)
+
+type Jellyfin struct {
+	client *Client
+	user   string
+}
+
+// Client calls the Jellyfin API, for the export as well as for the library checks
+type Client struct {
+	url   string
+	token string
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func NewClient(url string, token string) *Client {
+	return &Client{url: strings.TrimSuffix(url, "/"), token: token}
+}
+
+// PlayedFilm is a film watched in Jellyfin by the export user
+type PlayedFilm struct {
+	TmdbId      int
+	Title       string
+	Year        int
+	WatchedDate time.Time
+	// user rating out of 10, 0 when not rated
+	Rating10 int
+}
+
+var jf Jellyfin
+
+func Init(config c.JellyfinConfig) {
+	jf = Jellyfin{client: NewClient(config.Url, config.ApiKey), user: config.ExportUser}
+}
+
+// Get decodes the JSON response of the endpoint in out, unless out is nil
+func (c *Client) Get(endpoint string, query url.Values, out interface{}) error {
+	requestUrl := c.url + endpoint
+	if query != nil {
+		requestUrl += "?" + query.Encode()
+	}
+
+	r, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("X-Emby-Token", c.token)
+
+	start := time.Now()
+	res, err := httpClient.Do(r)
+	if err != nil {
+		metrics.ObserveAPICall("jellyfin", start, 0)
+		return err
+	}
+	defer res.Body.Close()
+	metrics.ObserveAPICall("jellyfin", start, res.StatusCode)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: got HTTP code %d", endpoint, res.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func userId(name string) (string, error) {
+	var users []struct {
+		Id   string `json:"Id"`
+		Name string `json:"Name"`
+	}
+	if err := jf.client.Get("/Users", nil, &users); err != nil {
+		return "", err
+	}
+
+	for _, u := range users {
+		if strings.EqualFold(u.Name, name) {
+			return u.Id, nil
+		}
+	}
+	return "", fmt.Errorf("no Jellyfin user named %s", name)
+}
+
+// GetPlayedFilms returns the films of the library played by the export user. Jellyfin only keeps the
+// last play date of an item, so a film watched several times between two exports is exported once
+func GetPlayedFilms() ([]PlayedFilm, error) {
+	id, err := userId(jf.user)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"Recursive":        {"true"},
+		"IncludeItemTypes": {"Movie"},
+		"Filters":          {"IsPlayed"},
+		"Fields":           {"ProviderIds"},
+	}
+	var res struct {
+		Items []struct {
+			Name           string            `json:"Name"`
+			ProductionYear int               `json:"ProductionYear"`
+			ProviderIds    map[string]string `json:"ProviderIds"`
+			UserData       struct {
+				LastPlayedDate time.Time `json:"LastPlayedDate"`
+				Rating         float64   `json:"Rating"`
+			} `json:"UserData"`
+		} `json:"Items"`
+	}
+	if err := jf.client.Get("/Users/"+id+"/Items", query, &res); err != nil {
+		return nil, err
+	}
+
+	var films []PlayedFilm
+	for _, item := range res.Items {
+		// Letterboxd matches the films on their TMDb id
+		tmdbId, err := strconv.Atoi(item.ProviderIds["Tmdb"])
+		if err != nil {
+			continue
+		}
+		films = append(films, PlayedFilm{
+			TmdbId:      tmdbId,
+			Title:       item.Name,
+			Year:        item.ProductionYear,
+			WatchedDate: item.UserData.LastPlayedDate,
+			Rating10:    int(item.UserData.Rating + 0.5),
+		})
+	}
+	return films, nil
+}
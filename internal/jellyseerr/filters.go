@@ -2,6 +2,7 @@ package jellyseerr
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/alozach/lbxd_seerr/internal/lxbd"
@@ -21,11 +22,76 @@ var availableFilters = [...]Filter{
 	{
 		Name: "vod_not_available",
 		FilterFunc: func(f lxbd.Film) (bool, string) {
-			return !f.VODAvailable, ""
+			details := ""
+			if len(f.VODProviders) > 0 {
+				details = "on " + strings.Join(f.VODProviders, ", ")
+			}
+			return !f.VODAvailable, details
+		}},
+	{
+		Name: "not_watched",
+		FilterFunc: func(f lxbd.Film) (bool, string) {
+			if f.Watched {
+				return false, "already watched"
+			}
+			return true, ""
+		}},
+	{
+		Name: "lbxd_min_rating",
+		FilterFunc: func(f lxbd.Film) (bool, string) {
+			if f.Ratings == nil || f.Ratings.Count == 0 {
+				return false, "no Letterboxd rating"
+			}
+			details := fmt.Sprintf("rating: %.2f, min %.2f", f.Ratings.Average, js.minRating)
+			return f.Ratings.Average >= js.minRating, details
+		}},
+	{
+		Name: "lbxd_min_ratings_count",
+		FilterFunc: func(f lxbd.Film) (bool, string) {
+			count := 0
+			if f.Ratings != nil {
+				count = f.Ratings.Count
+			}
+			details := fmt.Sprintf("%d ratings, min %d", count, js.minRatings)
+			return count >= js.minRatings, details
+		}},
+	{
+		Name: "lbxd_min_friends_rating",
+		FilterFunc: func(f lxbd.Film) (bool, string) {
+			// the friends have no say on the films none of them rated
+			if f.Ratings == nil || f.Ratings.FriendsCount == 0 {
+				return true, "not rated by friends"
+			}
+			details := fmt.Sprintf("friends rating: %.2f (%d), min %.2f", f.Ratings.FriendsAverage, f.Ratings.FriendsCount, js.minFriendsRating)
+			return f.Ratings.FriendsAverage >= js.minFriendsRating, details
+		}},
+	{
+		Name: "min_watchlist_age",
+		FilterFunc: func(f lxbd.Film) (bool, string) {
+			// films already there when dates started to be tracked are old enough
+			if f.AddedAt == nil {
+				return true, ""
+			}
+			age := time.Since(*f.AddedAt)
+			details := fmt.Sprintf("added %s ago, min %s", age.Round(time.Hour), js.minAge)
+			return age >= js.minAge, details
+		}},
+	{
+		Name: "max_watchlist_age",
+		FilterFunc: func(f lxbd.Film) (bool, string) {
+			if f.AddedAt == nil {
+				return false, "added before dates were tracked"
+			}
+			age := time.Since(*f.AddedAt)
+			details := fmt.Sprintf("added %s ago, max %s", age.Round(time.Hour), js.maxAge)
+			return age <= js.maxAge, details
 		}},
 	{
 		Name: "profitable",
 		FilterFunc: func(f lxbd.Film) (bool, string) {
+			if f.IsTV() {
+				return true, "not applicable to series"
+			}
 			details := fmt.Sprint("bud:", f.TmdbInfo.Budget, ", rev=", f.TmdbInfo.Revenue)
 			return (f.TmdbInfo.Revenue > f.TmdbInfo.Budget && f.TmdbInfo.Budget > 0), details
 		}},
@@ -39,6 +105,9 @@ var availableFilters = [...]Filter{
 			}
 
 			details := fmt.Sprint("release date: ", f.TmdbInfo.ReleaseDate)
+			if f.Release != nil {
+				details += fmt.Sprintf(" (%s %s)", f.Release.Country, lxbd.ReleaseTypeName(f.Release.Type))
+			}
 			return t.Before(currentTime), details
 		}},
 }
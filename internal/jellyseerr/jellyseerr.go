@@ -1,53 +1,94 @@
 package jellyseerr
 
 import (
-	"bytes"
-	"encoding/csv"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"os"
-	"strconv"
+	"slices"
+	"time"
 
+	"github.com/alozach/lbxd_seerr/internal/backend"
 	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/library"
 	"github.com/alozach/lbxd_seerr/internal/lxbd"
+	"github.com/alozach/lbxd_seerr/internal/overrides"
 )
 
 type Jellyseerr struct {
 	ReqFilters       []Filter
-	apiKey           string
-	url              string
-	requestedTMDbIds []int
+	backend          backend.Backend
+	requestedMedia   []string
 	requestsLimit    int
 	currNbRequests   int
+	minRating        float64
+	minRatings       int
+	minFriendsRating float64
+	minAge           time.Duration
+	maxAge           time.Duration
 }
 
 type RequestStatus string
 
 const (
-	REQ_OK               RequestStatus = "REQ_OK"
-	REQ_REACHED_LIMIT    RequestStatus = "REQ_REACHED_LIMIT"
-	REQ_MISSING_DATA     RequestStatus = "MISSING_DATA"
-	REQ_JELLYSEERR_ERROR RequestStatus = "JELLYSEERR_ERROR"
-	REQ_ALREADY_OK       RequestStatus = "ALREADY_REQUESTED"
-	REQ_FILTER_KO        RequestStatus = "FILTER_KO"
+	REQ_OK            RequestStatus = "REQ_OK"
+	REQ_REACHED_LIMIT RequestStatus = "REQ_REACHED_LIMIT"
+	REQ_MISSING_DATA  RequestStatus = "MISSING_DATA"
+	// the value predates the other backends, it is kept for the saved requests and the API clients
+	REQ_BACKEND_ERROR RequestStatus = "JELLYSEERR_ERROR"
+	REQ_ALREADY_OK    RequestStatus = "ALREADY_REQUESTED"
+	REQ_FILTER_KO     RequestStatus = "FILTER_KO"
+	REQ_FORCED        RequestStatus = "FORCED"
+	REQ_IGNORED       RequestStatus = "IGNORED"
+	REQ_IN_LIBRARY    RequestStatus = "IN_LIBRARY"
+	REQ_LIBRARY_ERROR RequestStatus = "LIBRARY_ERROR"
+	REQ_UNSUPPORTED   RequestStatus = "UNSUPPORTED"
 )
 
 type Request struct {
 	Film    lxbd.Film
 	Status  RequestStatus
 	Details string
+	Time    time.Time
 }
 
-const requestsFilename = "/app/data/last_requests.txt"
-
 var js Jellyseerr
 
 func Init(config c.JellyseerrConfig) {
-	js = Jellyseerr{apiKey: config.ApiKey, url: config.BaseUrl + "/api/v1", requestsLimit: config.RequestsLimit}
+	js = Jellyseerr{backend: backend.NewJellyseerr(config), requestsLimit: config.RequestsLimit}
+
+	// rating filters are enabled by their threshold
+	js.minRating = config.MinRating
+	if js.minRating > 0 {
+		AddFilter("lbxd_min_rating")
+	}
+	js.minRatings = config.MinRatings
+	if js.minRatings > 0 {
+		AddFilter("lbxd_min_ratings_count")
+	}
+	js.minFriendsRating = config.MinFriendsRating
+	if js.minFriendsRating > 0 {
+		AddFilter("lbxd_min_friends_rating")
+	}
+
+	// and the watchlist age ones by their duration
+	js.minAge = config.MinAge
+	if js.minAge > 0 {
+		AddFilter("min_watchlist_age")
+	}
+	js.maxAge = config.MaxAge
+	if js.maxAge > 0 {
+		AddFilter("max_watchlist_age")
+	}
+}
+
+// SetBackend sends the requests to another service than Jellyseerr
+func SetBackend(b backend.Backend) {
+	js.backend = b
+	log.Printf("Using %s as request backend", b.Name())
+}
+
+// BackendName returns the name of the service the requests are sent to
+func BackendName() string {
+	return js.backend.Name()
 }
 
 func AddFilter(filterName string) {
@@ -66,190 +107,132 @@ func AddFilters(filterNames []string) {
 	}
 }
 
-func APICall(endpoint string, method string, body io.Reader) (*http.Response, error) {
-	requestUrl := js.url + endpoint
-
-	r, err := http.NewRequest(method, requestUrl, body)
-	if err != nil {
-		log.Printf("Failed to create %s request: %s", method, err)
-		return nil, err
-	}
-
-	r.Header.Add("Content-Type", "application/json")
-	r.Header.Add("Accept", "application/json")
-	r.Header.Add("X-Api-Key", js.apiKey)
-
-	client := &http.Client{}
-	res, err := client.Do(r)
-	if err != nil {
-		log.Printf("Failed to send %s request: %s", method, err)
-		return nil, err
-	}
-	return res, nil
-}
-
+// RefreshRequestedTMDbIds gets the films already known by the request backend
 func RefreshRequestedTMDbIds() error {
-	res, err := APICall("/request", "GET", nil)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		log.Printf("Error getting Jellyseerr requests: got HTTP code %d", res.StatusCode)
-		return errors.New("HTTP request failure")
-	}
-
-	var res_obj map[string]interface{}
-	err = json.NewDecoder(res.Body).Decode(&res_obj)
+	keys, err := js.backend.ExistingMedia()
 	if err != nil {
-		log.Println("Error parsing Jellyseer /request response: ", err)
 		return err
 	}
 
-	var tmdbIds []int
-
-	results := res_obj["results"].([]interface{})
-	for _, result_if := range results {
-		result := result_if.(map[string]interface{})
-		media := result["media"].(map[string]interface{})
-		tmdbId := int(media["tmdbId"].(float64))
-		tmdbIds = append(tmdbIds, tmdbId)
-	}
-
-	js.requestedTMDbIds = tmdbIds
+	js.requestedMedia = keys
 	return nil
 }
 
+// CheckStatus checks that the request backend is up and accepts the API key
+func CheckStatus() error {
+	return js.backend.CheckStatus()
+}
+
 func ResetRequestsCounter() {
 	js.currNbRequests = 0
 }
 
+func dryRun() bool {
+	return slices.ContainsFunc(js.ReqFilters, func(f Filter) bool { return f.Name == "dry_run" })
+}
+
+// checkFilters returns false with the name and details of the first filter the film does not pass
+func checkFilters(film lxbd.Film) (bool, string) {
+	for _, filter := range js.ReqFilters {
+		filter_passed, details := filter.FilterFunc(film)
+		if !filter_passed {
+			retDetails := filter.Name
+			if details != "" {
+				retDetails += ": " + details
+			}
+			return false, retDetails
+		}
+	}
+	return true, ""
+}
+
 func CreateRequest(film lxbd.Film, refreshAlreadyRequested bool) Request {
-	req := Request{Film: film}
+	req := Request{Film: film, Time: time.Now()}
 
 	if film.TmdbInfo == nil {
 		req.Status = REQ_MISSING_DATA
 		return req
 	}
 
-	if js.requestedTMDbIds == nil || refreshAlreadyRequested {
+	if js.requestedMedia == nil || refreshAlreadyRequested {
 		if err := RefreshRequestedTMDbIds(); err != nil {
-			req.Status = REQ_JELLYSEERR_ERROR
+			req.Status = REQ_BACKEND_ERROR
 			req.Details = err.Error()
 			return req
 		}
-	}
 
-	for _, tmdbId := range js.requestedTMDbIds {
-		if tmdbId == film.TmdbInfo.ID {
-			req.Status = REQ_ALREADY_OK
-			return req
-		}
-	}
-
-	for _, filter := range js.ReqFilters {
-		filter_passed, details := filter.FilterFunc(film)
-		if !filter_passed {
-			retDetails := filter.Name
-			if details != "" {
-				retDetails += ": " + details
+		if library.Enabled() {
+			if err := library.Refresh(); err != nil {
+				req.Status = REQ_LIBRARY_ERROR
+				req.Details = fmt.Sprintf("%s library: %s", library.Name(), err)
+				return req
 			}
-			req.Status = REQ_FILTER_KO
-			req.Details = retDetails
-			return req
 		}
 	}
 
-	if js.requestsLimit > 0 && js.currNbRequests >= js.requestsLimit {
-		req.Status = REQ_REACHED_LIMIT
+	if film.IsTV() && !js.backend.HandlesSeries() {
+		req.Status = REQ_UNSUPPORTED
+		req.Details = js.backend.Name() + " does not handle series"
 		return req
 	}
 
-	body, _ := json.Marshal(map[string]interface{}{"mediaType": "movie", "mediaId": film.TmdbId, "userId": 2})
-
-	res, err := APICall("/request", http.MethodPost, bytes.NewBuffer(body))
-	if err != nil {
-		req.Status = REQ_JELLYSEERR_ERROR
-		req.Details = err.Error()
+	override, overridden := overrides.Get(film.Lid)
+	if overridden && override.Action == overrides.IGNORE {
+		req.Status = REQ_IGNORED
+		req.Details = fmt.Sprintf("ignored from %s", override.Source)
 		return req
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusCreated {
-		req.Status = REQ_JELLYSEERR_ERROR
-		req.Details = fmt.Sprint("Got HTTP code", res.StatusCode)
+	if slices.Contains(js.requestedMedia, film.MediaKey()) {
+		req.Status = REQ_ALREADY_OK
 		return req
 	}
 
-	js.requestedTMDbIds = append(js.requestedTMDbIds, film.TmdbId)
-	req.Status = REQ_OK
-	js.currNbRequests++
-	return req
-}
-
-func SaveRequests(requests []Request) error {
-	requestsFile, err := os.Create(requestsFilename)
-	if err != nil {
-		log.Println("Failed to create request data: ", err)
-		return err
-	}
-	defer requestsFile.Close()
-
-	writer := csv.NewWriter(requestsFile)
-	defer writer.Flush()
-
-	headers := []string{"tmdbId", "name", "status", "details"}
-
-	writer.Write(headers)
-	for _, req := range requests {
-		row := []string{strconv.Itoa(req.Film.TmdbId), req.Film.TmdbInfo.Title, string(req.Status), req.Details}
-		writer.Write(row)
+	// the film may have been added to the media server without being requested
+	if library.Enabled() {
+		inLibrary, err := library.Has(film.MediaType(), film.TmdbId)
+		if err != nil {
+			req.Status = REQ_LIBRARY_ERROR
+			req.Details = fmt.Sprintf("%s library: %s", library.Name(), err)
+			return req
+		}
+		if inLibrary {
+			req.Status = REQ_IN_LIBRARY
+			req.Details = "in " + library.Name() + " library"
+			return req
+		}
 	}
-	return nil
-}
-
-func GetLastRequets() ([]byte, error) {
-	file, err := os.Open(requestsFilename)
 
-	if err != nil {
-		log.Println("Error while reading the file", err)
-		return nil, err
+	// forced films do not go through the filters, but dry_run still keeps them from being requested
+	if !overridden {
+		if passed, details := checkFilters(film); !passed {
+			req.Status = REQ_FILTER_KO
+			req.Details = details
+			return req
+		}
+	} else if dryRun() {
+		req.Status = REQ_FILTER_KO
+		req.Details = fmt.Sprintf("dry_run: forced from %s", override.Source)
+		return req
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
 
-	headers, err := reader.Read()
-	if err != nil {
-		log.Fatal(err)
+	if js.requestsLimit > 0 && js.currNbRequests >= js.requestsLimit {
+		req.Status = REQ_REACHED_LIMIT
+		return req
 	}
 
-	// Read the CSV data rows
-	var data []map[string]interface{}
-	for {
-		row, err := reader.Read()
-		if err != nil {
-			break
-		}
-
-		m := make(map[string]interface{})
-		for i, val := range row {
-			valint, err := strconv.ParseInt(val, 10, 0)
-			if err == nil {
-				m[headers[i]] = valint
-				continue
-			}
-
-			m[headers[i]] = val
-		}
-		data = append(data, m)
+	if err := js.backend.Request(film); err != nil {
+		req.Status = REQ_BACKEND_ERROR
+		req.Details = err.Error()
+		return req
 	}
 
-	b, err := json.Marshal(data)
-	if err != nil {
-		log.Println(err)
-		return nil, err
+	js.requestedMedia = append(js.requestedMedia, film.MediaKey())
+	req.Status = REQ_OK
+	if overridden {
+		req.Status = REQ_FORCED
+		req.Details = fmt.Sprintf("forced from %s", override.Source)
 	}
-	return b, nil
+	js.currNbRequests++
+	return req
 }
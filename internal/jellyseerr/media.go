@@ -0,0 +1,91 @@
+package jellyseerr
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+type MediaStatus string
+
+const (
+	MEDIA_PENDING   MediaStatus = "PENDING"
+	MEDIA_APPROVED  MediaStatus = "APPROVED"
+	MEDIA_AVAILABLE MediaStatus = "AVAILABLE"
+	MEDIA_DECLINED  MediaStatus = "DECLINED"
+	MEDIA_FAILED    MediaStatus = "FAILED"
+)
+
+// TrackedMedia is the status of a requested film in Jellyseerr, as last reported by its webhooks.
+// They are kept by media key (see lxbd.MediaKey)
+type TrackedMedia struct {
+	TmdbId    int         `json:"tmdb_id"`
+	MediaType string      `json:"media_type,omitempty"`
+	Status    MediaStatus `json:"status"`
+	Time      time.Time   `json:"time"`
+}
+
+const mediaFilename = "/app/data/media_status.txt"
+
+var mediaMu sync.Mutex
+
+func readMediaStatuses() (map[string]TrackedMedia, error) {
+	statuses := map[string]TrackedMedia{}
+
+	file, err := os.Open(mediaFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return statuses, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+func SetMediaStatus(mediaType string, tmdbId int, status MediaStatus) error {
+	mediaMu.Lock()
+	defer mediaMu.Unlock()
+
+	statuses, err := readMediaStatuses()
+	if err != nil {
+		log.Println("Failed to read media statuses, starting from scratch: ", err)
+		statuses = map[string]TrackedMedia{}
+	}
+	statuses[lxbd.MediaKey(mediaType, tmdbId)] = TrackedMedia{TmdbId: tmdbId, MediaType: mediaType, Status: status, Time: time.Now()}
+
+	jsonData, err := json.Marshal(statuses)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(mediaFilename), os.ModePerm); err != nil {
+		log.Println("Failed to save media status: ", err)
+		return err
+	}
+	if err := os.WriteFile(mediaFilename, jsonData, 0644); err != nil {
+		log.Println("Failed to save media status: ", err)
+		return err
+	}
+	return nil
+}
+
+func GetMediaStatuses() map[string]TrackedMedia {
+	mediaMu.Lock()
+	defer mediaMu.Unlock()
+
+	statuses, err := readMediaStatuses()
+	if err != nil {
+		log.Println("Failed to read media statuses: ", err)
+		return map[string]TrackedMedia{}
+	}
+	return statuses
+}
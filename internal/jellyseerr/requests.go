@@ -0,0 +1,229 @@
+package jellyseerr
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SavedRequest is the outcome of a request as stored after a run
+type SavedRequest struct {
+	TmdbId  int           `json:"tmdb_id"`
+	Lid     int           `json:"lid"`
+	Title   string        `json:"title"`
+	Year    int           `json:"year"`
+	Status  RequestStatus `json:"status"`
+	Details string        `json:"details"`
+	Time    time.Time     `json:"timestamp"`
+}
+
+type RequestsQuery struct {
+	Statuses []RequestStatus
+	Search   string
+	// field to sort on, prefixed with "-" for descending order
+	Sort    string
+	Page    int
+	PerPage int
+}
+
+const requestsFilename = "/app/data/last_requests.txt"
+
+var requestsHeaders = []string{"tmdbId", "lid", "name", "year", "status", "details", "timestamp"}
+
+func newSavedRequest(req Request) SavedRequest {
+	saved := SavedRequest{
+		TmdbId:  req.Film.TmdbId,
+		Lid:     req.Film.Lid,
+		Status:  req.Status,
+		Details: req.Details,
+		Time:    req.Time,
+	}
+	if req.Film.TmdbInfo != nil {
+		saved.Title = req.Film.TmdbInfo.Title
+		if len(req.Film.TmdbInfo.ReleaseDate) >= 4 {
+			saved.Year, _ = strconv.Atoi(req.Film.TmdbInfo.ReleaseDate[:4])
+		}
+	}
+	return saved
+}
+
+func WriteRequestsCSV(w io.Writer, requests []SavedRequest) error {
+	writer := csv.NewWriter(w)
+
+	writer.Write(requestsHeaders)
+	for _, req := range requests {
+		row := []string{
+			strconv.Itoa(req.TmdbId),
+			strconv.Itoa(req.Lid),
+			req.Title,
+			strconv.Itoa(req.Year),
+			string(req.Status),
+			req.Details,
+			req.Time.Format(time.RFC3339),
+		}
+		writer.Write(row)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func SaveRequests(requests []Request) error {
+	if err := os.MkdirAll(filepath.Dir(requestsFilename), os.ModePerm); err != nil {
+		log.Println("Failed to create request data: ", err)
+		return err
+	}
+
+	requestsFile, err := os.Create(requestsFilename)
+	if err != nil {
+		log.Println("Failed to create request data: ", err)
+		return err
+	}
+	defer requestsFile.Close()
+
+	var saved []SavedRequest
+	for _, req := range requests {
+		saved = append(saved, newSavedRequest(req))
+	}
+	return WriteRequestsCSV(requestsFile, saved)
+}
+
+// GetSavedRequests reads the requests of the last run. Columns are looked up by
+// header so that files written by older versions can still be read
+func GetSavedRequests() ([]SavedRequest, error) {
+	file, err := os.Open(requestsFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, header := range rows[0] {
+		columns[header] = i
+	}
+	get := func(row []string, header string) string {
+		i, ok := columns[header]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+
+	var requests []SavedRequest
+	for _, row := range rows[1:] {
+		req := SavedRequest{
+			Title:   get(row, "name"),
+			Status:  RequestStatus(get(row, "status")),
+			Details: get(row, "details"),
+		}
+		req.TmdbId, _ = strconv.Atoi(get(row, "tmdbId"))
+		req.Lid, _ = strconv.Atoi(get(row, "lid"))
+		req.Year, _ = strconv.Atoi(get(row, "year"))
+		req.Time, _ = time.Parse(time.RFC3339, get(row, "timestamp"))
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+func requestLess(a SavedRequest, b SavedRequest, field string) bool {
+	switch field {
+	case "title":
+		return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+	case "year":
+		return a.Year < b.Year
+	case "status":
+		return a.Status < b.Status
+	case "timestamp":
+		return a.Time.Before(b.Time)
+	case "tmdb_id":
+		return a.TmdbId < b.TmdbId
+	case "lid":
+		return a.Lid < b.Lid
+	}
+	return false
+}
+
+// ValidSortField tells if requests can be sorted on field, with or without "-" prefix
+func ValidSortField(field string) bool {
+	switch strings.TrimPrefix(field, "-") {
+	case "", "title", "year", "status", "timestamp", "tmdb_id", "lid":
+		return true
+	}
+	return false
+}
+
+// QueryRequests filters, sorts and paginates the saved requests. It also returns the
+// number of requests matching the filters
+func QueryRequests(q RequestsQuery) ([]SavedRequest, int, error) {
+	requests, err := GetSavedRequests()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	search := strings.ToLower(q.Search)
+	matching := []SavedRequest{}
+	for _, req := range requests {
+		if len(q.Statuses) > 0 {
+			found := false
+			for _, status := range q.Statuses {
+				if req.Status == status {
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+
+		if search != "" && !strings.Contains(strings.ToLower(req.Title), search) {
+			continue
+		}
+		matching = append(matching, req)
+	}
+
+	if q.Sort != "" {
+		field := strings.TrimPrefix(q.Sort, "-")
+		desc := strings.HasPrefix(q.Sort, "-")
+		sort.SliceStable(matching, func(i, j int) bool {
+			if desc {
+				return requestLess(matching[j], matching[i], field)
+			}
+			return requestLess(matching[i], matching[j], field)
+		})
+	}
+
+	return paginate(matching, q.Page, q.PerPage), len(matching), nil
+}
+
+// paginate returns the requests of the page, all of them if perPage is 0
+func paginate(requests []SavedRequest, page int, perPage int) []SavedRequest {
+	if perPage <= 0 {
+		return requests
+	}
+
+	// compare page numbers rather than offsets, which overflow with huge pages
+	total := len(requests)
+	if page < 1 || page-1 > (total-1)/perPage {
+		return []SavedRequest{}
+	}
+
+	start := (page - 1) * perPage
+	end := start + min(perPage, total-start)
+	return requests[start:end]
+}
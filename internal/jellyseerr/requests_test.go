@@ -0,0 +1,47 @@
+package jellyseerr
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	requests := make([]SavedRequest, 5)
+	for i := range requests {
+		requests[i].TmdbId = i + 1
+	}
+
+	tests := []struct {
+		name    string
+		page    int
+		perPage int
+		want    []int
+	}{
+		{name: "no pagination", page: 1, perPage: 0, want: []int{1, 2, 3, 4, 5}},
+		{name: "first page", page: 1, perPage: 2, want: []int{1, 2}},
+		{name: "last partial page", page: 3, perPage: 2, want: []int{5}},
+		{name: "exact last page", page: 1, perPage: 5, want: []int{1, 2, 3, 4, 5}},
+		{name: "past the end", page: 4, perPage: 2, want: []int{}},
+		{name: "huge page", page: math.MaxInt, perPage: 500, want: []int{}},
+		{name: "huge page and per page", page: math.MaxInt, perPage: math.MaxInt, want: []int{}},
+		{name: "huge per page", page: 1, perPage: math.MaxInt, want: []int{1, 2, 3, 4, 5}},
+		{name: "second huge page", page: 2, perPage: math.MaxInt, want: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := paginate(requests, tt.page, tt.perPage)
+			if got == nil {
+				t.Fatal("got nil, want an empty page")
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d requests, want %d", len(got), len(tt.want))
+			}
+			for i, req := range got {
+				if req.TmdbId != tt.want[i] {
+					t.Errorf("request %d: got TMDb id %d, want %d", i, req.TmdbId, tt.want[i])
+				}
+			}
+		})
+	}
+}
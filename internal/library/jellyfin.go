@@ -0,0 +1,44 @@
+package library
+
+import (
+	"net/url"
+	"strconv"
+
+	jellyfinapi "github.com/alozach/lbxd_seerr/internal/jellyfin"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// jellyfin shares its API client with the watched films export
+type jellyfin struct {
+	client *jellyfinapi.Client
+}
+
+// films are looked up one by one, Jellyfin indexes the provider ids
+func (j *jellyfin) refresh() error {
+	return nil
+}
+
+func (j *jellyfin) has(mediaType string, tmdbId int) (bool, error) {
+	itemType := "Movie"
+	if mediaType == lxbd.TMDB_TV {
+		itemType = "Series"
+	}
+
+	query := url.Values{
+		"Recursive":           {"true"},
+		"IncludeItemTypes":    {itemType},
+		"AnyProviderIdEquals": {"Tmdb." + strconv.Itoa(tmdbId)},
+		"Limit":               {"1"},
+	}
+	var res struct {
+		TotalRecordCount int `json:"TotalRecordCount"`
+	}
+	if err := j.client.Get("/Items", query, &res); err != nil {
+		return false, err
+	}
+	return res.TotalRecordCount > 0, nil
+}
+
+func (j *jellyfin) check() error {
+	return j.client.Get("/System/Info", nil, nil)
+}
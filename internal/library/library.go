@@ -0,0 +1,92 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	jellyfinapi "github.com/alozach/lbxd_seerr/internal/jellyfin"
+	"github.com/alozach/lbxd_seerr/internal/metrics"
+)
+
+// mediaServer looks for films and series in the library of a media server, by TMDb id
+type mediaServer interface {
+	// refresh is called before checking the films of a run
+	refresh() error
+	has(mediaType string, tmdbId int) (bool, error)
+	check() error
+}
+
+var server mediaServer
+var serverType string
+
+// Plex answers the whole library listing at once, which may take a while on big libraries
+var client = &http.Client{Timeout: time.Minute}
+
+func Init(config c.LibraryConfig) {
+	url := strings.TrimSuffix(config.Url, "/")
+	switch config.Type {
+	case "jellyfin":
+		server = &jellyfin{client: jellyfinapi.NewClient(url, config.Token)}
+	case "plex":
+		server = &plex{url: url, token: config.Token}
+	default:
+		return
+	}
+	serverType = config.Type
+	log.Printf("Checking the %s library before requesting films", serverType)
+}
+
+func Enabled() bool {
+	return server != nil
+}
+
+func Name() string {
+	return serverType
+}
+
+func Refresh() error {
+	return server.refresh()
+}
+
+// Has tells whether the film or series (see lxbd.MediaType) is already in the media server library
+func Has(mediaType string, tmdbId int) (bool, error) {
+	return server.has(mediaType, tmdbId)
+}
+
+// CheckStatus checks that the media server is up and accepts the token
+func CheckStatus() error {
+	return server.check()
+}
+
+func getJSON(requestUrl string, headers map[string]string, out interface{}) error {
+	r, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	res, err := client.Do(r)
+	if err != nil {
+		metrics.ObserveAPICall(serverType, start, 0)
+		return err
+	}
+	defer res.Body.Close()
+	metrics.ObserveAPICall(serverType, start, res.StatusCode)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: got HTTP code %d", r.URL.Path, res.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
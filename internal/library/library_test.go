@@ -0,0 +1,145 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// standIn serves the routes as JSON when the token header holds "token"
+func standIn(t *testing.T, tokenHeader string, routes map[string]func(r *http.Request) interface{}) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(tokenHeader) != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		route, ok := routes[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(route(r))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+type lookup struct {
+	mediaType string
+	tmdbId    int
+	want      bool
+}
+
+func checkLookups(t *testing.T, lookups []lookup) {
+	for _, l := range lookups {
+		got, err := Has(l.mediaType, l.tmdbId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != l.want {
+			t.Errorf("%s %d: got %t, want %t", l.mediaType, l.tmdbId, got, l.want)
+		}
+	}
+}
+
+func TestJellyfin(t *testing.T) {
+	srv := standIn(t, "X-Emby-Token", map[string]func(r *http.Request) interface{}{
+		"/System/Info": func(r *http.Request) interface{} {
+			return map[string]string{"Version": "10.9.0"}
+		},
+		"/Items": func(r *http.Request) interface{} {
+			q := r.URL.Query()
+			count := 0
+			if (q.Get("IncludeItemTypes") == "Movie" && q.Get("AnyProviderIdEquals") == "Tmdb.603") ||
+				(q.Get("IncludeItemTypes") == "Series" && q.Get("AnyProviderIdEquals") == "Tmdb.1399") {
+				count = 1
+			}
+			return map[string]int{"TotalRecordCount": count}
+		},
+	})
+
+	Init(c.LibraryConfig{Type: "jellyfin", Url: srv.URL + "/", Token: "token"})
+	if err := CheckStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	checkLookups(t, []lookup{
+		{lxbd.TMDB_MOVIE, 603, true},
+		{lxbd.TMDB_MOVIE, 604, false},
+		{lxbd.TMDB_TV, 1399, true},
+		{lxbd.TMDB_TV, 603, false},
+	})
+
+	Init(c.LibraryConfig{Type: "jellyfin", Url: srv.URL, Token: "wrong"})
+	if err := CheckStatus(); err == nil {
+		t.Error("a wrong token passed")
+	}
+	if _, err := Has(lxbd.TMDB_MOVIE, 603); err == nil {
+		t.Error("a wrong token passed")
+	}
+}
+
+func TestPlex(t *testing.T) {
+	metadata := func(guids ...string) interface{} {
+		var items []interface{}
+		for _, guid := range guids {
+			items = append(items, map[string]interface{}{"Guid": []map[string]string{{"id": "imdb://tt0133093"}, {"id": guid}}})
+		}
+		return map[string]interface{}{"MediaContainer": map[string]interface{}{"Metadata": items}}
+	}
+	srv := standIn(t, "X-Plex-Token", map[string]func(r *http.Request) interface{}{
+		"/identity": func(r *http.Request) interface{} {
+			return map[string]interface{}{"MediaContainer": map[string]string{"version": "1.40.0"}}
+		},
+		"/library/sections": func(r *http.Request) interface{} {
+			return map[string]interface{}{"MediaContainer": map[string]interface{}{"Directory": []map[string]string{
+				{"key": "1", "type": "movie"},
+				{"key": "2", "type": "show"},
+				{"key": "3", "type": "artist"},
+			}}}
+		},
+		"/library/sections/1/all": func(r *http.Request) interface{} {
+			return metadata("tmdb://603", "tmdb://not-an-id", "plex://movie/5d7768")
+		},
+		"/library/sections/2/all": func(r *http.Request) interface{} {
+			return metadata("tmdb://1399")
+		},
+	})
+
+	Init(c.LibraryConfig{Type: "plex", Url: srv.URL, Token: "token"})
+	if err := CheckStatus(); err != nil {
+		t.Fatal(err)
+	}
+	// the library is loaded on first lookup when not refreshed
+	checkLookups(t, []lookup{
+		{lxbd.TMDB_MOVIE, 603, true},
+		{lxbd.TMDB_MOVIE, 1399, false},
+		{lxbd.TMDB_TV, 1399, true},
+		{lxbd.TMDB_TV, 603, false},
+	})
+	if err := Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.(*plex).media); n != 2 {
+		t.Errorf("got %d media, want 2", n)
+	}
+
+	Init(c.LibraryConfig{Type: "plex", Url: srv.URL, Token: "wrong"})
+	if err := Refresh(); err == nil {
+		t.Error("a wrong token passed")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	server, serverType = nil, ""
+	Init(c.LibraryConfig{})
+	if Enabled() {
+		t.Error("enabled without a type")
+	}
+}
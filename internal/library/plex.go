@@ -0,0 +1,94 @@
+package library
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// Plex can't filter on external ids, so the TMDb ids of all the movie and show libraries are loaded on refresh
+type plex struct {
+	url   string
+	token string
+	// media keys, see lxbd.MediaKey
+	media map[string]bool
+}
+
+// Plex library types with their item type number and TMDb media type
+var plexSectionTypes = map[string]struct {
+	itemType  int
+	mediaType string
+}{
+	"movie": {1, lxbd.TMDB_MOVIE},
+	"show":  {2, lxbd.TMDB_TV},
+}
+
+type plexContainer struct {
+	MediaContainer struct {
+		Directory []struct {
+			Key  string `json:"key"`
+			Type string `json:"type"`
+		} `json:"Directory"`
+		Metadata []struct {
+			Guid []struct {
+				Id string `json:"id"`
+			} `json:"Guid"`
+		} `json:"Metadata"`
+	} `json:"MediaContainer"`
+}
+
+func (p *plex) get(endpoint string, out interface{}) error {
+	return getJSON(p.url+endpoint, map[string]string{"X-Plex-Token": p.token}, out)
+}
+
+func (p *plex) refresh() error {
+	var sections plexContainer
+	if err := p.get("/library/sections", &sections); err != nil {
+		return err
+	}
+
+	media := map[string]bool{}
+	for _, dir := range sections.MediaContainer.Directory {
+		sectionType, ok := plexSectionTypes[dir.Type]
+		if !ok {
+			continue
+		}
+
+		var movies plexContainer
+		endpoint := fmt.Sprintf("/library/sections/%s/all?type=%d&includeGuids=1", dir.Key, sectionType.itemType)
+		if err := p.get(endpoint, &movies); err != nil {
+			return err
+		}
+		for _, m := range movies.MediaContainer.Metadata {
+			for _, guid := range m.Guid {
+				id, found := strings.CutPrefix(guid.Id, "tmdb://")
+				if !found {
+					continue
+				}
+				if tmdbId, err := strconv.Atoi(id); err == nil {
+					media[lxbd.MediaKey(sectionType.mediaType, tmdbId)] = true
+				}
+			}
+		}
+	}
+
+	log.Printf("Got %d films and series in Plex library", len(media))
+	p.media = media
+	return nil
+}
+
+func (p *plex) has(mediaType string, tmdbId int) (bool, error) {
+	if p.media == nil {
+		if err := p.refresh(); err != nil {
+			return false, err
+		}
+	}
+	return p.media[lxbd.MediaKey(mediaType, tmdbId)], nil
+}
+
+func (p *plex) check() error {
+	return p.get("/identity", nil)
+}
@@ -6,35 +6,140 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ryanbradynd05/go-tmdb"
 )
 
 type Film struct {
-	Lid          int         `json:"lid"`
-	TmdbId       int         `json:"tmdbId"`
-	LxbdEndpoint string      `json:"link"`
-	VODAvailable bool        `json:"vod_available"`
-	TmdbInfo     *tmdb.Movie `json:"tmdb_info"`
+	Lid          int          `json:"lid"`
+	TmdbId       int          `json:"tmdbId"`
+	TmdbType     string       `json:"tmdb_type,omitempty"`
+	LxbdEndpoint string       `json:"link"`
+	VODAvailable bool         `json:"vod_available"`
+	VODProviders []string     `json:"vod_providers,omitempty"`
+	Watched      bool         `json:"watched"`
+	Ratings      *Ratings     `json:"ratings,omitempty"`
+	TmdbInfo     *tmdb.Movie  `json:"tmdb_info"`
+	Release      *ReleaseDate `json:"release,omitempty"`
+	// seasons of a series, without the specials
+	Seasons      []Season   `json:"tv_seasons,omitempty"`
+	ExpandedFrom *Expansion `json:"expanded_from,omitempty"`
+	// first time the film was seen in the watchlist (see FirstSeen), nil for the films already
+	// there when this started to be tracked
+	AddedAt *time.Time `json:"added_at,omitempty"`
+}
+
+// Expansion is the watchlist film another film of a TMDb collection was added for
+type Expansion struct {
+	Lid        int    `json:"lid"`
+	Title      string `json:"title"`
+	Collection string `json:"collection"`
+}
+
+func (e Expansion) String() string {
+	return fmt.Sprintf("from %s, for %s", e.Collection, e.Title)
+}
+
+// Letterboxd lists miniseries and TV movies with a TMDb series id, films without type are treated as movies
+const (
+	TMDB_MOVIE = "movie"
+	TMDB_TV    = "tv"
+)
+
+func (f Film) IsTV() bool {
+	return f.TmdbType == TMDB_TV
+}
+
+// TypeKnown tells whether the TMDb type of the film was recorded when resolving it, films resolved
+// before series were handled have no type although they may be series
+func (f Film) TypeKnown() bool {
+	return f.TmdbType != ""
+}
+
+// MediaType is the TMDb type of the film, movie or tv
+func (f Film) MediaType() string {
+	if f.IsTV() {
+		return TMDB_TV
+	}
+	return TMDB_MOVIE
+}
+
+// MediaKey identifies a movie or a series, TMDb ids of both kinds overlapping
+func MediaKey(mediaType string, tmdbId int) string {
+	if mediaType == TMDB_TV {
+		return fmt.Sprintf("tv/%d", tmdbId)
+	}
+	return strconv.Itoa(tmdbId)
+}
+
+func (f Film) MediaKey() string {
+	return MediaKey(f.MediaType(), f.TmdbId)
+}
+
+// Season is a season of a series, AirDate being empty when not announced yet
+type Season struct {
+	Number  int    `json:"number"`
+	AirDate string `json:"air_date,omitempty"`
+}
+
+// Ratings are the Letterboxd community and friends ratings of a film, taken from its pages
+type Ratings struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+	Fans    int     `json:"fans"`
+	// out of 5, over the friends of the user who rated the film
+	FriendsAverage float64   `json:"friends_average"`
+	FriendsCount   int       `json:"friends_count"`
+	FetchedAt      time.Time `json:"fetched_at"`
+}
+
+// ReleaseDate is a release of a film in a country, Type being a TMDb release type code
+type ReleaseDate struct {
+	Country string `json:"country"`
+	Type    int    `json:"type"`
+	Date    string `json:"date"`
+}
+
+var ReleaseTypes = map[string]int{
+	"premiere":           1,
+	"theatrical_limited": 2,
+	"theatrical":         3,
+	"digital":            4,
+	"physical":           5,
+	"tv":                 6,
+}
+
+func ReleaseTypeName(releaseType int) string {
+	for name, t := range ReleaseTypes {
+		if t == releaseType {
+			return name
+		}
+	}
+	return "unknown"
 }
 
 const filmsFilename = "/app/data/films.txt"
 
-func SaveFilms(films []Film) error {
+// films added from the TMDb collections of the watchlist films, kept apart from the watchlist
+const expandedFilename = "/app/data/expanded_films.txt"
+
+func saveFilms(filename string, films []Film) error {
 	jsonData, err := json.Marshal(films)
 	if err != nil {
 		return err
 	}
 
-	split := strings.Split(filmsFilename, "/")
+	split := strings.Split(filename, "/")
 	dirName := fmt.Sprint("/", filepath.Join(split[:len(split)-1]...))
 	if err := os.MkdirAll(dirName, os.ModePerm); err != nil {
 		log.Println("Failed to save request data: ", err)
 		return err
 	}
 
-	file, err := os.Create(filmsFilename)
+	file, err := os.Create(filename)
 	if err != nil {
 		log.Println("Failed to save request data: ", err)
 		return err
@@ -51,8 +156,8 @@ func SaveFilms(films []Film) error {
 	return nil
 }
 
-func GetSavedFilms() ([]Film, error) {
-	file, err := os.Open(filmsFilename)
+func getSavedFilms(filename string) ([]Film, error) {
+	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -65,3 +170,154 @@ func GetSavedFilms() ([]Film, error) {
 	}
 	return films, nil
 }
+
+// SaveFilms saves the watchlist films
+func SaveFilms(films []Film) error {
+	return saveFilms(filmsFilename, films)
+}
+
+func GetSavedFilms() ([]Film, error) {
+	return getSavedFilms(filmsFilename)
+}
+
+func SaveExpanded(films []Film) error {
+	return saveFilms(expandedFilename, films)
+}
+
+func GetSavedExpanded() ([]Film, error) {
+	return getSavedFilms(expandedFilename)
+}
+
+// GetSavedWithExpanded returns the watchlist films followed by the films expanded from them
+func GetSavedWithExpanded() ([]Film, error) {
+	films, err := GetSavedFilms()
+	if err != nil {
+		return nil, err
+	}
+	// there are no expanded films until a run completed with expand_collections
+	expanded, err := GetSavedExpanded()
+	if err != nil {
+		return films, nil
+	}
+	return append(films, expanded...), nil
+}
+
+// FirstSeen keeps the date each watchlist film was first seen, as Letterboxd does not show when films
+// were added. Films already in the watchlist when the tracking started have a zero date
+type FirstSeen struct {
+	TrackingStarted time.Time         `json:"tracking_started"`
+	Lids            map[int]time.Time `json:"lids"`
+}
+
+const firstSeenFilename = "/app/data/watchlist_first_seen.txt"
+
+func GetFirstSeen() (*FirstSeen, error) {
+	firstSeen := &FirstSeen{Lids: map[int]time.Time{}}
+
+	data, err := os.ReadFile(firstSeenFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return firstSeen, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, firstSeen); err != nil {
+		return nil, err
+	}
+	if firstSeen.Lids == nil {
+		firstSeen.Lids = map[int]time.Time{}
+	}
+	return firstSeen, nil
+}
+
+// Track sets the date the films were first seen, dating the new ones now. Films no longer in the
+// watchlist are forgotten, so that a film added again is dated again
+func (fs *FirstSeen) Track(films []Film) {
+	now := time.Now()
+	lids := make(map[int]time.Time, len(films))
+	for i, f := range films {
+		date, ok := fs.Lids[f.Lid]
+		switch {
+		case ok:
+		case fs.TrackingStarted.IsZero():
+			// the films were dated from the previous films data before the tracking had its own file
+			if f.AddedAt != nil {
+				date = *f.AddedAt
+			}
+		default:
+			date = now
+		}
+		lids[f.Lid] = date
+
+		films[i].AddedAt = nil
+		if !date.IsZero() {
+			films[i].AddedAt = &date
+		}
+	}
+
+	if fs.TrackingStarted.IsZero() {
+		fs.TrackingStarted = now
+	}
+	fs.Lids = lids
+}
+
+func (fs *FirstSeen) Save() error {
+	if err := os.MkdirAll(filepath.Dir(firstSeenFilename), os.ModePerm); err != nil {
+		log.Println("Failed to save watchlist dates: ", err)
+		return err
+	}
+
+	jsonData, err := json.Marshal(fs)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(firstSeenFilename, jsonData, 0644); err != nil {
+		log.Println("Failed to save watchlist dates: ", err)
+		return err
+	}
+	return nil
+}
+
+// the ids of the films in the user watched list, kept to only read the new ones on next runs
+const watchedFilename = "/app/data/watched.txt"
+
+type Watched struct {
+	Lids []int `json:"lids"`
+	// the whole list is read again periodically, recent pages miss backdated logs and removed films
+	FullReadAt time.Time `json:"full_read_at"`
+}
+
+func SaveWatched(watched Watched) error {
+	if err := os.MkdirAll(filepath.Dir(watchedFilename), os.ModePerm); err != nil {
+		log.Println("Failed to save watched films: ", err)
+		return err
+	}
+
+	jsonData, err := json.Marshal(watched)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(watchedFilename, jsonData, 0644); err != nil {
+		log.Println("Failed to save watched films: ", err)
+		return err
+	}
+	return nil
+}
+
+func GetSavedWatched() (Watched, error) {
+	data, err := os.ReadFile(watchedFilename)
+	if err != nil {
+		return Watched{}, err
+	}
+
+	var watched Watched
+	if err := json.Unmarshal(data, &watched); err == nil {
+		return watched, nil
+	}
+	// previous format without the full read date, which then happens on next run
+	var lids []int
+	if err := json.Unmarshal(data, &lids); err != nil {
+		return Watched{}, err
+	}
+	return Watched{Lids: lids}, nil
+}
@@ -0,0 +1,72 @@
+package lxbd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrack(t *testing.T) {
+	started := time.Now().Add(-30 * 24 * time.Hour)
+	seen := started.Add(24 * time.Hour)
+
+	fs := &FirstSeen{TrackingStarted: started, Lids: map[int]time.Time{
+		1: {},   // already in the watchlist when the tracking started
+		2: seen, // added since
+		3: seen, // removed from the watchlist
+	}}
+	films := []Film{{Lid: 1}, {Lid: 2}, {Lid: 4}}
+
+	before := time.Now()
+	fs.Track(films)
+
+	if films[0].AddedAt != nil {
+		t.Errorf("got %s for a film there before the tracking", films[0].AddedAt)
+	}
+	if films[1].AddedAt == nil || !films[1].AddedAt.Equal(seen) {
+		t.Errorf("got %v for a known film, want %s", films[1].AddedAt, seen)
+	}
+	if films[2].AddedAt == nil || films[2].AddedAt.Before(before) {
+		t.Errorf("got %v for a new film", films[2].AddedAt)
+	}
+	if _, ok := fs.Lids[3]; ok || len(fs.Lids) != 3 {
+		t.Errorf("got %v, the removed film is not forgotten", fs.Lids)
+	}
+	if !fs.TrackingStarted.Equal(started) {
+		t.Errorf("tracking started at %s, want %s", fs.TrackingStarted, started)
+	}
+
+	// a film added again is dated again
+	fs.Track([]Film{{Lid: 1}, {Lid: 4}})
+	films = []Film{{Lid: 1}, {Lid: 2}}
+	fs.Track(films)
+	if films[0].AddedAt != nil || films[1].AddedAt == nil || films[1].AddedAt.Before(before) {
+		t.Errorf("got %v, %v", films[0].AddedAt, films[1].AddedAt)
+	}
+}
+
+func TestTrackMigration(t *testing.T) {
+	added := time.Now().Add(-24 * time.Hour)
+	fs := &FirstSeen{Lids: map[int]time.Time{}}
+	// the dates come from the previous films data until the tracking started
+	films := []Film{{Lid: 1}, {Lid: 2, AddedAt: &added}}
+
+	before := time.Now()
+	fs.Track(films)
+
+	if films[0].AddedAt != nil {
+		t.Errorf("got %s for a film without date", films[0].AddedAt)
+	}
+	if films[1].AddedAt == nil || !films[1].AddedAt.Equal(added) {
+		t.Errorf("got %v, want %s", films[1].AddedAt, added)
+	}
+	if fs.TrackingStarted.Before(before) {
+		t.Errorf("tracking started at %s", fs.TrackingStarted)
+	}
+
+	// the next new films are dated when seen
+	films = append(films, Film{Lid: 3})
+	fs.Track(films)
+	if films[0].AddedAt != nil || films[2].AddedAt == nil || films[2].AddedAt.Before(before) {
+		t.Errorf("got %v, %v", films[0].AddedAt, films[2].AddedAt)
+	}
+}
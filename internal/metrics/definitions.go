@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+var (
+	Requests = NewCounter("lbxd_seerr_requests_total",
+		"Film requests handled, by request status", "status")
+	Runs = NewCounter("lbxd_seerr_runs_total",
+		"Task runs, by task and result", "task", "status")
+	RunDuration = NewHistogram("lbxd_seerr_run_duration_seconds",
+		"Duration of task runs", []float64{10, 30, 60, 120, 300, 600, 1200, 3600}, "task")
+	LastSuccessfulRun = NewGauge("lbxd_seerr_last_successful_run_timestamp_seconds",
+		"Unix time of the last successful run of each task", "task")
+	WatchlistSize = NewGauge("lbxd_seerr_watchlist_size",
+		"Number of films in the watchlist at the last run")
+	ScraperErrors = NewCounter("lbxd_seerr_scraper_errors_total",
+		"Scrapping errors, by error class", "class")
+	APIRequests = NewCounter("lbxd_seerr_api_requests_total",
+		"Calls to external APIs, by API and HTTP status code", "api", "code")
+	APIDuration = NewHistogram("lbxd_seerr_api_request_duration_seconds",
+		"Latency of calls to external APIs", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "api")
+)
+
+// ObserveAPICall records a call to an external API started at start, code being 0 if
+// no response was received
+func ObserveAPICall(api string, start time.Time, code int) {
+	codeStr := "error"
+	if code != 0 {
+		codeStr = strconv.Itoa(code)
+	}
+	APIRequests.Inc(api, codeStr)
+	APIDuration.Observe(time.Since(start).Seconds(), api)
+}
@@ -0,0 +1,242 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/activity"
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
+)
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+// templates with the same name in this folder replace the default ones
+const templatesDir = "/config/templates"
+
+const lastDigestFilename = "/app/data/last_digest.txt"
+
+// period of the first digest, when no previous one was sent
+const defaultDigestPeriod = 7 * 24 * time.Hour
+
+type Digest struct {
+	Since        time.Time
+	Until        time.Time
+	Requested    []activity.Film
+	Available    []activity.Film
+	Skipped      []activity.Film
+	LimitReached []activity.Film
+}
+
+func (d Digest) Empty() bool {
+	return len(d.Requested)+len(d.Available)+len(d.Skipped)+len(d.LimitReached) == 0
+}
+
+func readTemplate(name string) (string, error) {
+	if b, err := os.ReadFile(filepath.Join(templatesDir, name)); err == nil {
+		return string(b), nil
+	}
+	b, err := defaultTemplates.ReadFile("templates/" + name)
+	return string(b), err
+}
+
+func lastDigestTime(now time.Time) time.Time {
+	b, err := os.ReadFile(lastDigestFilename)
+	if err == nil {
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(b))); err == nil {
+			return t
+		}
+	}
+	return now.Add(-defaultDigestPeriod)
+}
+
+// BuildDigest gathers the activity since the last digest. Skipped and blocked films
+// are the ones of the last run, as they are decided again on each run
+func BuildDigest(now time.Time) (Digest, error) {
+	d := Digest{Since: lastDigestTime(now), Until: now}
+
+	events, err := activity.Since(d.Since)
+	if err != nil {
+		return d, err
+	}
+	for _, e := range events {
+		switch e.Kind {
+		case activity.ACT_REQUESTED:
+			d.Requested = append(d.Requested, e.Film)
+		case activity.ACT_AVAILABLE:
+			d.Available = append(d.Available, e.Film)
+		}
+	}
+
+	requests, err := jellyseerr.GetSavedRequests()
+	if err != nil {
+		log.Println("No last requests for the digest: ", err)
+	}
+	for _, req := range requests {
+		film := activity.Film{TmdbId: req.TmdbId, Lid: req.Lid, Title: req.Title, Year: req.Year, Status: string(req.Status), Details: req.Details}
+		switch req.Status {
+		case jellyseerr.REQ_FILTER_KO, jellyseerr.REQ_IGNORED:
+			d.Skipped = append(d.Skipped, film)
+		case jellyseerr.REQ_REACHED_LIMIT:
+			d.LimitReached = append(d.LimitReached, film)
+		}
+	}
+	return d, nil
+}
+
+func renderDigest(d Digest) (string, string, error) {
+	textSrc, err := readTemplate("digest.txt")
+	if err != nil {
+		return "", "", err
+	}
+	textTmpl, err := texttemplate.New("digest.txt").Parse(textSrc)
+	if err != nil {
+		return "", "", err
+	}
+	var text bytes.Buffer
+	if err := textTmpl.Execute(&text, d); err != nil {
+		return "", "", err
+	}
+
+	htmlSrc, err := readTemplate("digest.html")
+	if err != nil {
+		return "", "", err
+	}
+	htmlTmpl, err := htmltemplate.New("digest.html").Parse(htmlSrc)
+	if err != nil {
+		return "", "", err
+	}
+	var html bytes.Buffer
+	if err := htmlTmpl.Execute(&html, d); err != nil {
+		return "", "", err
+	}
+
+	return text.String(), html.String(), nil
+}
+
+func buildMessage(config c.EmailConfig, subject string, text string, html string) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		w.Write([]byte(part.content))
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func sendMail(config c.EmailConfig, msg []byte) error {
+	addr := net.JoinHostPort(config.SmtpHost, strconv.Itoa(config.SmtpPort))
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.SmtpHost)
+	}
+
+	if !config.ImplicitTLS {
+		// uses STARTTLS when the server supports it
+		return smtp.SendMail(addr, auth, config.From, config.To, msg)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: config.SmtpHost})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, config.SmtpHost)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(config.From); err != nil {
+		return err
+	}
+	for _, to := range config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// SendDigest emails the activity since the previous digest
+func SendDigest(config c.EmailConfig) error {
+	now := time.Now()
+	d, err := BuildDigest(now)
+	if err != nil {
+		return err
+	}
+
+	text, html, err := renderDigest(d)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Watchlist digest: %d requested, %d available", len(d.Requested), len(d.Available))
+	msg, err := buildMessage(config, subject, text, html)
+	if err != nil {
+		return err
+	}
+
+	if err := sendMail(config, msg); err != nil {
+		return err
+	}
+	log.Printf("Digest sent to %s", strings.Join(config.To, ", "))
+
+	if err := os.MkdirAll(filepath.Dir(lastDigestFilename), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(lastDigestFilename, []byte(now.Format(time.RFC3339)), 0644)
+}
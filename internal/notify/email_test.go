@@ -0,0 +1,183 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/activity"
+	c "github.com/alozach/lbxd_seerr/internal/config"
+)
+
+// smtpMessage is what the in-process SMTP stand-in received
+type smtpMessage struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn accepts a single SMTP session, without TLS, and sends what it received on the channel
+func smtpStandIn(t *testing.T) (string, int, <-chan smtpMessage) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan smtpMessage, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+		var msg smtpMessage
+		reply("220 localhost ESMTP stand-in")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch cmd {
+			case "EHLO", "HELO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				msg.auth = line
+				reply("235 2.7.0 Authentication successful")
+			case "MAIL":
+				msg.from = line
+				reply("250 OK")
+			case "RCPT":
+				msg.to = append(msg.to, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(l, "."))
+				}
+				msg.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				received <- msg
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNb, _ := strconv.Atoi(port)
+	return host, portNb, received
+}
+
+var testDigest = Digest{
+	Since:        time.Date(2026, 10, 12, 8, 0, 0, 0, time.UTC),
+	Until:        time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC),
+	Requested:    []activity.Film{{TmdbId: 603, Title: "The Matrix", Year: 1999}},
+	Available:    []activity.Film{{TmdbId: 550, Title: "Fight Club", Year: 1999}},
+	Skipped:      []activity.Film{{TmdbId: 13, Title: "Forrest Gump", Year: 1994, Status: "FILTER_KO", Details: "profitable"}},
+	LimitReached: []activity.Film{{TmdbId: 680, Title: "Pulp Fiction", Year: 1994}},
+}
+
+func TestSendDigest(t *testing.T) {
+	host, port, received := smtpStandIn(t)
+	config := c.EmailConfig{
+		SmtpHost: host,
+		SmtpPort: port,
+		Username: "user",
+		Password: "secret",
+		From:     "lbxd_seerr@example.com",
+		To:       []string{"alice@example.com", "bob@example.com"},
+	}
+
+	text, html, err := renderDigest(testDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := buildMessage(config, "Watchlist digest: 1 requested, 1 available", text, html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendMail(config, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var got smtpMessage
+	select {
+	case got = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	wantAuth := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00user\x00secret"))
+	if got.auth != wantAuth {
+		t.Errorf("got %q, want %q", got.auth, wantAuth)
+	}
+	if got.from != "MAIL FROM:<lbxd_seerr@example.com>" && !strings.HasPrefix(got.from, "MAIL FROM:<lbxd_seerr@example.com> ") {
+		t.Errorf("got %q", got.from)
+	}
+	if len(got.to) != 2 || !strings.Contains(got.to[0], "alice@example.com") || !strings.Contains(got.to[1], "bob@example.com") {
+		t.Errorf("got recipients %v", got.to)
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if subject != "Watchlist digest: 1 requested, 1 available" {
+		t.Errorf("got subject %q", subject)
+	}
+	if got := m.Header.Get("To"); got != "alice@example.com, bob@example.com" {
+		t.Errorf("got To %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got Content-Type %q", m.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(m.Body, params["boundary"])
+	for _, wantType := range []string{"text/plain", "text/html"} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatalf("missing %s part: %s", wantType, err)
+		}
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), wantType) {
+			t.Errorf("got part %q, want %s", part.Header.Get("Content-Type"), wantType)
+		}
+		body, _ := io.ReadAll(part)
+		for _, title := range []string{"The Matrix", "Fight Club", "Forrest Gump", "Pulp Fiction"} {
+			if !strings.Contains(string(body), title) {
+				t.Errorf("%s part does not contain %s", wantType, title)
+			}
+		}
+	}
+}
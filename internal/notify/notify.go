@@ -0,0 +1,197 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/activity"
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
+	"github.com/alozach/lbxd_seerr/internal/runs"
+)
+
+type Event string
+
+const (
+	EVENT_NEW_REQUEST    Event = "new_request"
+	EVENT_ERROR          Event = "error"
+	EVENT_FILM_AVAILABLE Event = "film_available"
+	EVENT_LIMIT_REACHED  Event = "limit_reached"
+)
+
+// Notification is what is sent to every target, Films being the films the events are about
+type Notification struct {
+	Events  []Event         `json:"events"`
+	Title   string          `json:"title"`
+	Message string          `json:"message"`
+	Films   []activity.Film `json:"films"`
+	Run     *runs.Run       `json:"run,omitempty"`
+
+	Requested    []activity.Film `json:"requested,omitempty"`
+	Failed       []activity.Film `json:"failed,omitempty"`
+	LimitReached []activity.Film `json:"limit_reached,omitempty"`
+}
+
+type Notifier interface {
+	Name() string
+	Send(n Notification) error
+}
+
+type target struct {
+	notifier Notifier
+	events   []Event
+}
+
+var targets []target
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+func Init(configs []c.NotifierConfig) {
+	targets = nil
+	for _, config := range configs {
+		var notifier Notifier
+		var err error
+		switch config.Type {
+		case "webhook":
+			notifier, err = newWebhook(config)
+		case "discord":
+			notifier = &discord{url: config.Url}
+		case "ntfy":
+			notifier = &ntfy{url: config.Url, token: config.Token}
+		case "gotify":
+			notifier = &gotify{url: config.Url, token: config.Token}
+		default:
+			err = fmt.Errorf("unknown notifier type \"%s\"", config.Type)
+		}
+		if err != nil {
+			log.Println("Invalid notifier config: ", err)
+			continue
+		}
+
+		var events []Event
+		for _, e := range config.Events {
+			events = append(events, Event(e))
+		}
+		targets = append(targets, target{notifier: notifier, events: events})
+	}
+}
+
+// Dispatch sends the notification to every target opted in one of its events
+func Dispatch(n Notification) {
+	for _, t := range targets {
+		wanted := false
+		for _, e := range n.Events {
+			if slices.Contains(t.events, e) {
+				wanted = true
+			}
+		}
+		if !wanted {
+			continue
+		}
+
+		if err := t.notifier.Send(n); err != nil {
+			log.Printf("Failed to send %s notification: %s", t.notifier.Name(), err)
+		}
+	}
+}
+
+// RunFinished sends the summary of a dl_watchlist run
+func RunFinished(run *runs.Run, requests []jellyseerr.Request) {
+	n := Notification{Run: run}
+	for _, req := range requests {
+		switch req.Status {
+		case jellyseerr.REQ_OK, jellyseerr.REQ_FORCED:
+			n.Requested = append(n.Requested, activity.NewFilm(req))
+		case jellyseerr.REQ_BACKEND_ERROR, jellyseerr.REQ_LIBRARY_ERROR, jellyseerr.REQ_MISSING_DATA:
+			n.Failed = append(n.Failed, activity.NewFilm(req))
+		case jellyseerr.REQ_REACHED_LIMIT:
+			n.LimitReached = append(n.LimitReached, activity.NewFilm(req))
+		}
+	}
+
+	var lines []string
+	if len(n.Requested) > 0 {
+		n.Events = append(n.Events, EVENT_NEW_REQUEST)
+		lines = append(lines, fmt.Sprintf("%d films requested", len(n.Requested)))
+		for _, f := range n.Requested {
+			lines = append(lines, "- "+f.String())
+		}
+	}
+	if run.Status == runs.RUN_FAILED || len(n.Failed) > 0 {
+		n.Events = append(n.Events, EVENT_ERROR)
+		if run.Status == runs.RUN_FAILED {
+			lines = append(lines, "Run failed: "+run.Error)
+		}
+		for _, f := range n.Failed {
+			lines = append(lines, fmt.Sprintf("- %s failed: %s %s", f, f.Status, f.Details))
+		}
+	}
+	if len(n.LimitReached) > 0 {
+		n.Events = append(n.Events, EVENT_LIMIT_REACHED)
+		lines = append(lines, fmt.Sprintf("%d films not requested because of the requests limit", len(n.LimitReached)))
+		for _, f := range n.LimitReached {
+			lines = append(lines, "- "+f.String())
+		}
+	}
+
+	if len(n.Events) == 0 {
+		return
+	}
+
+	n.Title = "Watchlist sync"
+	if run.Status == runs.RUN_FAILED {
+		n.Title = "Watchlist sync failed"
+	}
+	n.Message = strings.Join(lines, "\n")
+	n.Films = append(append(append([]activity.Film{}, n.Requested...), n.Failed...), n.LimitReached...)
+	Dispatch(n)
+}
+
+// FilmAvailable notifies that a requested film can now be watched
+func FilmAvailable(film activity.Film) {
+	Dispatch(Notification{
+		Events:  []Event{EVENT_FILM_AVAILABLE},
+		Title:   "Film available",
+		Message: film.String() + " is now available",
+		Films:   []activity.Film{film},
+	})
+}
+
+// RequestFailed notifies that Jellyseerr declined or failed a request
+func RequestFailed(film activity.Film) {
+	Dispatch(Notification{
+		Events:  []Event{EVENT_ERROR},
+		Title:   "Request " + strings.ToLower(film.Status),
+		Message: fmt.Sprintf("The request of %s was %s in Jellyseerr", film, strings.ToLower(film.Status)),
+		Films:   []activity.Film{film},
+	})
+}
+
+func post(url string, contentType string, body []byte, headers map[string]string) error {
+	r, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+
+	res, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("got HTTP code %d: %s", res.StatusCode, msg)
+	}
+	return nil
+}
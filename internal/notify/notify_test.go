@@ -0,0 +1,254 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ryanbradynd05/go-tmdb"
+
+	"github.com/alozach/lbxd_seerr/internal/activity"
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/jellyseerr"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+	"github.com/alozach/lbxd_seerr/internal/runs"
+)
+
+// received is a request got by the stand-in server
+type received struct {
+	path   string
+	query  string
+	header http.Header
+	body   []byte
+}
+
+func standIn(t *testing.T) (*httptest.Server, *[]received) {
+	var got []received
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, received{path: r.URL.Path, query: r.URL.RawQuery, header: r.Header, body: body})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &got
+}
+
+var testFilm = activity.Film{TmdbId: 603, Lid: 51518, Title: "The Matrix", Year: 1999, PosterUrl: "https://image.tmdb.org/t/p/w342/matrix.jpg", Link: "https://letterboxd.com/film/the-matrix/", Status: "REQ_OK"}
+
+var testNotification = Notification{
+	Events:    []Event{EVENT_NEW_REQUEST},
+	Title:     "Watchlist sync",
+	Message:   "1 films requested",
+	Films:     []activity.Film{testFilm},
+	Requested: []activity.Film{testFilm},
+}
+
+func TestTargets(t *testing.T) {
+	tests := []struct {
+		name   string
+		config c.NotifierConfig
+		check  func(t *testing.T, r received)
+	}{
+		{
+			name:   "webhook",
+			config: c.NotifierConfig{Type: "webhook", Token: "secret"},
+			check: func(t *testing.T, r received) {
+				if got := r.header.Get("Authorization"); got != "Bearer secret" {
+					t.Errorf("got Authorization %q", got)
+				}
+				var n Notification
+				if err := json.Unmarshal(r.body, &n); err != nil {
+					t.Fatal(err)
+				}
+				if n.Title != testNotification.Title || len(n.Requested) != 1 || n.Requested[0].TmdbId != 603 {
+					t.Errorf("got notification %+v", n)
+				}
+			},
+		},
+		{
+			name:   "webhook template",
+			config: c.NotifierConfig{Type: "webhook", Template: `{"text": {{json .Message}}, "films": {{len .Films}}}`},
+			check: func(t *testing.T, r received) {
+				if got := string(r.body); got != `{"text": "1 films requested", "films": 1}` {
+					t.Errorf("got body %s", got)
+				}
+			},
+		},
+		{
+			name:   "discord",
+			config: c.NotifierConfig{Type: "discord"},
+			check: func(t *testing.T, r received) {
+				var msg struct {
+					Content string `json:"content"`
+					Embeds  []struct {
+						Title     string `json:"title"`
+						Url       string `json:"url"`
+						Thumbnail struct {
+							Url string `json:"url"`
+						} `json:"thumbnail"`
+					} `json:"embeds"`
+				}
+				if err := json.Unmarshal(r.body, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(msg.Content, "Watchlist sync") {
+					t.Errorf("got content %q", msg.Content)
+				}
+				if len(msg.Embeds) != 1 || msg.Embeds[0].Title != "The Matrix (1999)" || msg.Embeds[0].Thumbnail.Url != testFilm.PosterUrl {
+					t.Errorf("got embeds %+v", msg.Embeds)
+				}
+			},
+		},
+		{
+			name:   "ntfy",
+			config: c.NotifierConfig{Type: "ntfy", Token: "secret"},
+			check: func(t *testing.T, r received) {
+				if r.path != "/topic" {
+					t.Errorf("got path %s", r.path)
+				}
+				want := map[string]string{"Title": "Watchlist sync", "Attach": testFilm.PosterUrl, "Click": testFilm.Link, "Authorization": "Bearer secret"}
+				for k, v := range want {
+					if got := r.header.Get(k); got != v {
+						t.Errorf("got %s %q, want %q", k, got, v)
+					}
+				}
+				if string(r.body) != testNotification.Message {
+					t.Errorf("got body %q", r.body)
+				}
+			},
+		},
+		{
+			name:   "gotify",
+			config: c.NotifierConfig{Type: "gotify", Token: "secret"},
+			check: func(t *testing.T, r received) {
+				if r.path != "/message" || r.query != "" {
+					t.Errorf("got url %s?%s, the token must not be in the url", r.path, r.query)
+				}
+				if got := r.header.Get("X-Gotify-Key"); got != "secret" {
+					t.Errorf("got X-Gotify-Key %q", got)
+				}
+				var msg struct {
+					Title    string `json:"title"`
+					Message  string `json:"message"`
+					Priority int    `json:"priority"`
+				}
+				if err := json.Unmarshal(r.body, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.Title != "Watchlist sync" || msg.Priority != 5 || !strings.Contains(msg.Message, testFilm.PosterUrl) {
+					t.Errorf("got message %+v", msg)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, got := standIn(t)
+			tt.config.Url = srv.URL
+			if tt.config.Type == "ntfy" {
+				tt.config.Url += "/topic"
+			}
+			tt.config.Events = []string{string(EVENT_NEW_REQUEST)}
+			Init([]c.NotifierConfig{tt.config})
+			if len(targets) != 1 {
+				t.Fatalf("got %d targets", len(targets))
+			}
+
+			Dispatch(testNotification)
+			if len(*got) != 1 {
+				t.Fatalf("got %d requests, want 1", len(*got))
+			}
+			tt.check(t, (*got)[0])
+		})
+	}
+}
+
+func testRequest(tmdbId int, title string, status jellyseerr.RequestStatus) jellyseerr.Request {
+	return jellyseerr.Request{
+		Film:   lxbd.Film{TmdbId: tmdbId, TmdbInfo: &tmdb.Movie{ID: tmdbId, Title: title, ReleaseDate: "1999-03-31"}},
+		Status: status,
+	}
+}
+
+func TestRunFinished(t *testing.T) {
+	okRun := &runs.Run{Task: "dl_watchlist", Status: runs.RUN_OK}
+	failedRun := &runs.Run{Task: "dl_watchlist", Status: runs.RUN_FAILED, Error: "login failed"}
+
+	tests := []struct {
+		name       string
+		run        *runs.Run
+		requests   []jellyseerr.Request
+		events     []string
+		wantEvents []Event
+	}{
+		{
+			name:       "requested",
+			run:        okRun,
+			requests:   []jellyseerr.Request{testRequest(603, "The Matrix", jellyseerr.REQ_OK), testRequest(604, "The Matrix Reloaded", jellyseerr.REQ_FILTER_KO)},
+			events:     []string{"new_request"},
+			wantEvents: []Event{EVENT_NEW_REQUEST},
+		},
+		{
+			name:       "limit reached only",
+			run:        okRun,
+			requests:   []jellyseerr.Request{testRequest(603, "The Matrix", jellyseerr.REQ_REACHED_LIMIT)},
+			events:     []string{"limit_reached"},
+			wantEvents: []Event{EVENT_LIMIT_REACHED},
+		},
+		{
+			name:     "limit reached not opted in",
+			run:      okRun,
+			requests: []jellyseerr.Request{testRequest(603, "The Matrix", jellyseerr.REQ_REACHED_LIMIT)},
+			events:   []string{"new_request", "error"},
+		},
+		{
+			name:       "run failed",
+			run:        failedRun,
+			events:     []string{"error"},
+			wantEvents: []Event{EVENT_ERROR},
+		},
+		{
+			name:     "nothing happened",
+			run:      okRun,
+			requests: []jellyseerr.Request{testRequest(603, "The Matrix", jellyseerr.REQ_ALREADY_OK)},
+			events:   []string{"new_request", "error", "limit_reached"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, got := standIn(t)
+			Init([]c.NotifierConfig{{Type: "webhook", Url: srv.URL, Events: tt.events}})
+
+			RunFinished(tt.run, tt.requests)
+
+			if tt.wantEvents == nil {
+				if len(*got) != 0 {
+					t.Fatalf("got %d notifications, want none", len(*got))
+				}
+				return
+			}
+			if len(*got) != 1 {
+				t.Fatalf("got %d notifications, want 1", len(*got))
+			}
+			var n Notification
+			if err := json.Unmarshal((*got)[0].body, &n); err != nil {
+				t.Fatal(err)
+			}
+			if len(n.Events) != len(tt.wantEvents) {
+				t.Fatalf("got events %v, want %v", n.Events, tt.wantEvents)
+			}
+			for i := range n.Events {
+				if n.Events[i] != tt.wantEvents[i] {
+					t.Errorf("got events %v, want %v", n.Events, tt.wantEvents)
+				}
+			}
+			if n.Message == "" || len(n.Films) == 0 && tt.run.Status == runs.RUN_OK {
+				t.Errorf("got notification %+v", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+)
+
+// webhook posts the notification as JSON, or the result of the configured template
+type webhook struct {
+	url      string
+	token    string
+	template *template.Template
+}
+
+func newWebhook(config c.NotifierConfig) (*webhook, error) {
+	w := &webhook{url: config.Url, token: config.Token}
+	if config.Template != "" {
+		tmpl, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(config.Template)
+		if err != nil {
+			return nil, err
+		}
+		w.template = tmpl
+	}
+	return w, nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (w *webhook) Name() string {
+	return "webhook"
+}
+
+func (w *webhook) Send(n Notification) error {
+	var body []byte
+	if w.template != nil {
+		var buf bytes.Buffer
+		if err := w.template.Execute(&buf, n); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	} else {
+		var err error
+		if body, err = json.Marshal(n); err != nil {
+			return err
+		}
+	}
+
+	headers := map[string]string{}
+	if w.token != "" {
+		headers["Authorization"] = "Bearer " + w.token
+	}
+	return post(w.url, "application/json", body, headers)
+}
+
+type discord struct {
+	url string
+}
+
+func (d *discord) Name() string {
+	return "discord"
+}
+
+// Discord accepts up to 10 embeds per message
+const discordMaxEmbeds = 10
+
+func (d *discord) Send(n Notification) error {
+	type thumbnail struct {
+		Url string `json:"url"`
+	}
+	type embed struct {
+		Title       string     `json:"title"`
+		Url         string     `json:"url,omitempty"`
+		Description string     `json:"description,omitempty"`
+		Thumbnail   *thumbnail `json:"thumbnail,omitempty"`
+	}
+
+	var embeds []embed
+	for _, f := range n.Films {
+		if len(embeds) == discordMaxEmbeds {
+			break
+		}
+		e := embed{Title: f.String(), Url: f.Link, Description: strings.TrimSpace(f.Status + " " + f.Details)}
+		if f.PosterUrl != "" {
+			e.Thumbnail = &thumbnail{Url: f.PosterUrl}
+		}
+		embeds = append(embeds, e)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"content": fmt.Sprintf("**%s**\n%s", n.Title, n.Message),
+		"embeds":  embeds,
+	})
+	if err != nil {
+		return err
+	}
+	return post(d.url, "application/json", body, nil)
+}
+
+// ntfy posts to a topic url, e.g. https://ntfy.sh/my_topic
+type ntfy struct {
+	url   string
+	token string
+}
+
+func (t *ntfy) Name() string {
+	return "ntfy"
+}
+
+func (t *ntfy) Send(n Notification) error {
+	headers := map[string]string{"Title": n.Title, "Tags": "movie_camera"}
+	for _, e := range n.Events {
+		if e == EVENT_ERROR {
+			headers["Priority"] = "high"
+			headers["Tags"] = "warning"
+		}
+	}
+	if len(n.Films) == 1 && n.Films[0].PosterUrl != "" {
+		headers["Attach"] = n.Films[0].PosterUrl
+	}
+	if len(n.Films) == 1 && n.Films[0].Link != "" {
+		headers["Click"] = n.Films[0].Link
+	}
+	if t.token != "" {
+		headers["Authorization"] = "Bearer " + t.token
+	}
+	return post(t.url, "text/plain", []byte(n.Message), headers)
+}
+
+// gotify posts to the server url with an application token
+type gotify struct {
+	url   string
+	token string
+}
+
+func (g *gotify) Name() string {
+	return "gotify"
+}
+
+func (g *gotify) Send(n Notification) error {
+	priority := 5
+	for _, e := range n.Events {
+		if e == EVENT_ERROR {
+			priority = 8
+		}
+	}
+
+	// markdown allows to show posters in the Gotify clients
+	message := n.Message
+	for _, f := range n.Films {
+		if f.PosterUrl != "" {
+			message += fmt.Sprintf("\n\n![%s](%s)", f.Title, f.PosterUrl)
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    n.Title,
+		"message":  message,
+		"priority": priority,
+		"extras": map[string]interface{}{
+			"client::display": map[string]string{"contentType": "text/markdown"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// a token in the url would end up in the proxies and servers logs
+	headers := map[string]string{"X-Gotify-Key": g.token}
+	return post(strings.TrimSuffix(g.url, "/")+"/message", "application/json", body, headers)
+}
@@ -0,0 +1,163 @@
+package overrides
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+)
+
+type Action string
+
+const (
+	FORCE  Action = "force"
+	IGNORE Action = "ignore"
+)
+
+type Source string
+
+const (
+	SOURCE_API    Source = "api"
+	SOURCE_CONFIG Source = "config"
+)
+
+// Override is a manual decision taken for a film, bypassing the request filters
+type Override struct {
+	Lid    int       `json:"lid"`
+	Action Action    `json:"action"`
+	Source Source    `json:"source"`
+	Time   time.Time `json:"time,omitempty"`
+}
+
+const overridesFilename = "/app/data/overrides.txt"
+
+var (
+	mu        sync.Mutex
+	overrides map[int]Override
+	// overrides from the config file, they take precedence over the ones set through the API
+	configured map[int]Override
+)
+
+func Init(config c.OverridesConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	configured = map[int]Override{}
+	for _, lid := range config.Force {
+		configured[lid] = Override{Lid: lid, Action: FORCE, Source: SOURCE_CONFIG}
+	}
+	for _, lid := range config.Ignore {
+		if _, ok := configured[lid]; ok {
+			log.Printf("Film %d is both forced and ignored in config, ignoring it", lid)
+		}
+		configured[lid] = Override{Lid: lid, Action: IGNORE, Source: SOURCE_CONFIG}
+	}
+}
+
+// load reads the overrides file on first use, mu must be held
+func load() {
+	if overrides != nil {
+		return
+	}
+	overrides = map[int]Override{}
+
+	file, err := os.Open(overridesFilename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Failed to open overrides: ", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&overrides); err != nil {
+		log.Println("Failed to read overrides: ", err)
+	}
+}
+
+// save writes the overrides file, mu must be held
+func save() error {
+	jsonData, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(overridesFilename), os.ModePerm); err != nil {
+		log.Println("Failed to save overrides: ", err)
+		return err
+	}
+
+	if err := os.WriteFile(overridesFilename, jsonData, 0644); err != nil {
+		log.Println("Failed to save overrides: ", err)
+		return err
+	}
+	return nil
+}
+
+func Set(lid int, action Action) error {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	if o, ok := configured[lid]; ok {
+		return fmt.Errorf("film %d is set to %s in config", lid, o.Action)
+	}
+
+	overrides[lid] = Override{Lid: lid, Action: action, Source: SOURCE_API, Time: time.Now()}
+	log.Printf("Film %d set to %s", lid, action)
+	return save()
+}
+
+func Clear(lid int) error {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	if o, ok := configured[lid]; ok {
+		return fmt.Errorf("film %d is set to %s in config", lid, o.Action)
+	}
+
+	delete(overrides, lid)
+	return save()
+}
+
+// Get returns the override of a film, films not found on Letterboxd having none
+func Get(lid int) (Override, bool) {
+	if lid == 0 {
+		return Override{}, false
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	if o, ok := configured[lid]; ok {
+		return o, true
+	}
+	o, ok := overrides[lid]
+	return o, ok
+}
+
+func GetAll() []Override {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+
+	all := []Override{}
+	for _, o := range configured {
+		all = append(all, o)
+	}
+	for lid, o := range overrides {
+		if _, ok := configured[lid]; !ok {
+			all = append(all, o)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Lid < all[j].Lid })
+	return all
+}
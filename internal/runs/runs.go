@@ -0,0 +1,116 @@
+package runs
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/tmdbcache"
+)
+
+type RunStatus string
+
+const (
+	RUN_RUNNING RunStatus = "RUNNING"
+	RUN_OK      RunStatus = "OK"
+	RUN_FAILED  RunStatus = "FAILED"
+)
+
+type Run struct {
+	Task          string    `json:"task"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Status        RunStatus `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	ErrorClass    string    `json:"error_class,omitempty"`
+	WatchlistSize int       `json:"watchlist_size"`
+	NbRequested   int       `json:"nb_requested"`
+
+	TMDbCache tmdbcache.Stats `json:"tmdb_cache"`
+}
+
+const runsFilename = "/app/data/runs.txt"
+
+// only the most recent runs are kept in the history file
+const maxSavedRuns = 100
+
+func Start(task string) *Run {
+	return &Run{Task: task, Start: time.Now(), Status: RUN_RUNNING}
+}
+
+func (r *Run) Fail(err error, class string) {
+	r.End = time.Now()
+	r.Status = RUN_FAILED
+	r.Error = err.Error()
+	r.ErrorClass = class
+}
+
+func (r *Run) Succeed() {
+	r.End = time.Now()
+	r.Status = RUN_OK
+}
+
+func (r *Run) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// Save appends the run to the history file
+func Save(r *Run) error {
+	history, err := GetRuns()
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("Failed to read runs history, starting a new one: ", err)
+	}
+
+	history = append(history, *r)
+	if len(history) > maxSavedRuns {
+		history = history[len(history)-maxSavedRuns:]
+	}
+
+	jsonData, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(runsFilename), os.ModePerm); err != nil {
+		log.Println("Failed to save run: ", err)
+		return err
+	}
+
+	if err := os.WriteFile(runsFilename, jsonData, 0644); err != nil {
+		log.Println("Failed to save run: ", err)
+		return err
+	}
+	return nil
+}
+
+// GetRuns returns the saved runs, oldest first
+func GetRuns() ([]Run, error) {
+	file, err := os.Open(runsFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var history []Run
+	if err := json.NewDecoder(file).Decode(&history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// LastSuccessful returns the most recent successful run of the task, nil if none
+func LastSuccessful(task string) *Run {
+	history, err := GetRuns()
+	if err != nil {
+		return nil
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Task == task && history[i].Status == RUN_OK {
+			return &history[i]
+		}
+	}
+	return nil
+}
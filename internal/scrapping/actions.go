@@ -0,0 +1,98 @@
+package scrapping
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// lxbdPostScript posts a form from the logged in browser, with the CSRF token Letterboxd
+// expects from its own pages
+const lxbdPostScript = `
+const [url, params, done] = arguments;
+const csrf = document.cookie.match(/com\.xk72\.webparts\.csrf=([^;]+)/);
+if (!csrf) {
+	done({error: "no CSRF cookie"});
+	return;
+}
+const body = new URLSearchParams(params);
+body.set("__csrf", csrf[1]);
+fetch(url, {method: "POST", body: body, credentials: "same-origin"})
+	.then(res => res.json()
+		.then(json => done({status: res.status, result: json.result !== false}))
+		.catch(() => done({status: res.status, result: res.ok})))
+	.catch(err => done({error: String(err)}));
+`
+
+// lxbdPost calls one of the (unofficial) endpoints used by the Letterboxd website
+func (scrapping *Scrapping) lxbdPost(endpoint string, params map[string]string) error {
+	current, err := scrapping.Driver.CurrentURL()
+	if err != nil || !strings.HasPrefix(current, lxbdBaseUrl) {
+		if err := scrapping.loadPage(lxbdBaseUrl); err != nil {
+			return err
+		}
+	}
+
+	if err := scrapping.Driver.SetAsyncScriptTimeout(scrapping.pageTimeout); err != nil {
+		return driverError("setting script timeout", err)
+	}
+
+	args := map[string]interface{}{}
+	for k, v := range params {
+		args[k] = v
+	}
+	res, err := scrapping.Driver.ExecuteScriptAsync(lxbdPostScript, []interface{}{lxbdBaseUrl + endpoint, args})
+	if err != nil {
+		return driverError("posting to "+endpoint, err)
+	}
+
+	result, _ := res.(map[string]interface{})
+	if msg, ok := result["error"].(string); ok {
+		return classify(ErrNetwork, endpoint, errors.New(msg))
+	}
+	status, _ := result["status"].(float64)
+	if int(status) == 429 {
+		return classify(ErrRateLimited, endpoint, nil)
+	}
+	if ok, _ := result["result"].(bool); !ok || status != 200 {
+		return fmt.Errorf("%s: got HTTP code %d", endpoint, int(status))
+	}
+	return nil
+}
+
+func (scrapping *Scrapping) LxbdMarkWatched(film lxbd.Film) error {
+	if err := scrapping.lxbdPost(film.LxbdEndpoint+"mark-as-watched/", nil); err != nil {
+		log.Printf("Failed to mark lid %d as watched: %s", film.Lid, err)
+		return err
+	}
+	log.Printf("Marked lid %d as watched", film.Lid)
+	return nil
+}
+
+func (scrapping *Scrapping) LxbdRemoveFromWatchlist(film lxbd.Film) error {
+	if err := scrapping.lxbdPost(film.LxbdEndpoint+"remove-from-watchlist/", nil); err != nil {
+		log.Printf("Failed to remove lid %d from watchlist: %s", film.Lid, err)
+		return err
+	}
+	log.Printf("Removed lid %d from watchlist", film.Lid)
+	return nil
+}
+
+func (scrapping *Scrapping) LxbdLogDiaryEntry(film lxbd.Film, watchedAt time.Time) error {
+	params := map[string]string{
+		"filmId":         strconv.Itoa(film.Lid),
+		"specifiedDate":  "true",
+		"viewingDateStr": watchedAt.Format("2006-01-02"),
+	}
+	if err := scrapping.lxbdPost("/s/save-diary-entry", params); err != nil {
+		log.Printf("Failed to log diary entry of lid %d: %s", film.Lid, err)
+		return err
+	}
+	log.Printf("Logged diary entry of lid %d on %s", film.Lid, watchedAt.Format("2006-01-02"))
+	return nil
+}
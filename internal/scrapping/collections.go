@@ -0,0 +1,112 @@
+package scrapping
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+	"github.com/alozach/lbxd_seerr/internal/tmdbcache"
+)
+
+func (s *Scrapping) fetchCollection(id int) ([]tmdbcache.CollectionPart, error) {
+	if parts, ok := s.TMDbCache.GetCollection(id); ok {
+		return parts, nil
+	}
+
+	var res struct {
+		Parts []tmdbcache.CollectionPart `json:"parts"`
+	}
+	params := url.Values{"language": {s.tmdbLanguage}}
+	if err := s.tmdbGet(fmt.Sprintf("/collection/%d", id), params, &res); err != nil {
+		return nil, err
+	}
+	s.TMDbCache.PutCollection(id, res.Parts)
+	return res.Parts, nil
+}
+
+// ExpandCollections returns the released movies of the TMDb collections of the films which
+// are not in films yet, with their TMDb info and Letterboxd page info like watchlist films.
+// The Letterboxd info of the previously expanded films is reused
+func (s *Scrapping) ExpandCollections(films []lxbd.Film, previous []lxbd.Film) []lxbd.Film {
+	known := map[int]bool{}
+	for _, f := range films {
+		if !f.IsTV() {
+			known[f.TmdbId] = true
+		}
+	}
+	previousFilms := map[int]lxbd.Film{}
+	for _, f := range previous {
+		previousFilms[f.TmdbId] = f
+	}
+
+	today := time.Now().Format("2006-01-02")
+	seen := map[int]bool{}
+	var expanded []lxbd.Film
+	for _, f := range films {
+		if f.IsTV() || f.TmdbInfo == nil {
+			continue
+		}
+		collection := f.TmdbInfo.BelongsToCollection
+		if collection.ID == 0 || seen[collection.ID] {
+			continue
+		}
+		seen[collection.ID] = true
+
+		parts, err := s.fetchCollection(collection.ID)
+		if err != nil {
+			log.Printf("Failed to get TMDb collection %s: %s", collection.Name, err)
+			CountError(err)
+			continue
+		}
+
+		for _, part := range parts {
+			// the primary release date is enough to leave out the announced films
+			if known[part.ID] || part.ReleaseDate == "" || part.ReleaseDate > today {
+				continue
+			}
+			known[part.ID] = true
+
+			film, ok := previousFilms[part.ID]
+			if !ok {
+				log.Printf("Adding %s from collection %s of %s", part.Title, collection.Name, f.TmdbInfo.Title)
+				film = lxbd.Film{TmdbId: part.ID, TmdbType: lxbd.TMDB_MOVIE, LxbdEndpoint: fmt.Sprintf("/tmdb/%d/", part.ID)}
+			}
+			film.TmdbInfo = nil
+			film.ExpandedFrom = &lxbd.Expansion{Lid: f.Lid, Title: f.TmdbInfo.Title, Collection: collection.Name}
+			film.AddedAt = f.AddedAt
+			expanded = append(expanded, film)
+		}
+	}
+
+	if len(expanded) == 0 {
+		return nil
+	}
+
+	// expanded must not be appended to anymore, the workers write through pointers to its elements
+	var toVisit []*lxbd.Film
+	var toFetch []tmdbFetch
+	for i := range expanded {
+		if expanded[i].Lid == 0 || s.ratingsStale(expanded[i]) {
+			toVisit = append(toVisit, &expanded[i])
+		}
+	}
+	if len(toVisit) > 0 {
+		s.visitFilmPages(toVisit)
+	}
+	for i := range expanded {
+		if fetch := s.cachedTMDbInfo(&expanded[i]); fetch != nil {
+			toFetch = append(toFetch, *fetch)
+		}
+	}
+	s.fetchTMDbInfos(toFetch)
+
+	var fetched []lxbd.Film
+	for _, film := range expanded {
+		if film.TmdbInfo != nil {
+			fetched = append(fetched, film)
+		}
+	}
+	return fetched
+}
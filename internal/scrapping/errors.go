@@ -0,0 +1,87 @@
+package scrapping
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/tebeka/selenium"
+
+	"github.com/alozach/lbxd_seerr/internal/metrics"
+)
+
+var (
+	ErrLoginFailed   = errors.New("login failed")
+	ErrLayoutChanged = errors.New("layout changed")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrNetwork       = errors.New("network error")
+)
+
+var errorClasses = []error{ErrLoginFailed, ErrLayoutChanged, ErrRateLimited, ErrNetwork}
+
+// ErrorClass returns a short name for the class of a scrapping error, to be used in reports
+func ErrorClass(err error) string {
+	for _, class := range errorClasses {
+		if errors.Is(err, class) {
+			return strings.ReplaceAll(class.Error(), " ", "_")
+		}
+	}
+	return "unknown"
+}
+
+// CountError records a scrapping error in the metrics
+func CountError(err error) {
+	metrics.ScraperErrors.Inc(ErrorClass(err))
+}
+
+func classify(class error, context string, err error) error {
+	if err == nil {
+		return fmt.Errorf("%w: %s", class, context)
+	}
+	return fmt.Errorf("%w: %s: %s", class, context, err)
+}
+
+// driverError guesses the class of an error returned by the WebDriver
+func driverError(context string, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return classify(ErrNetwork, context, err)
+	}
+
+	var seleniumErr *selenium.Error
+	if errors.As(err, &seleniumErr) {
+		switch seleniumErr.Err {
+		case "no such element", "stale element reference", "element not interactable":
+			return classify(ErrLayoutChanged, context, err)
+		case "unknown error":
+			if strings.Contains(seleniumErr.Message, "net::") {
+				return classify(ErrNetwork, context, err)
+			}
+		}
+	}
+	return fmt.Errorf("%s: %w", context, err)
+}
+
+func isTransient(err error) bool {
+	return errors.Is(err, ErrNetwork) || errors.Is(err, ErrRateLimited)
+}
+
+// withRetry runs f until it succeeds, returns a non transient error or the
+// configured number of attempts is reached, doubling the delay between attempts
+func (scrapping *Scrapping) withRetry(op string, f func() error) error {
+	delay := scrapping.retryBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || !isTransient(err) || attempt >= scrapping.retries {
+			return err
+		}
+
+		log.Printf("%s failed (attempt %d/%d), retrying in %s: %s", op, attempt, scrapping.retries, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
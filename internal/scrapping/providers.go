@@ -0,0 +1,96 @@
+package scrapping
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+type watchProvider struct {
+	ProviderId   int    `json:"provider_id"`
+	ProviderName string `json:"provider_name"`
+}
+
+// offers of a film in a region, by offer type
+type regionProviders struct {
+	Flatrate []watchProvider `json:"flatrate"`
+	Free     []watchProvider `json:"free"`
+	Ads      []watchProvider `json:"ads"`
+	Rent     []watchProvider `json:"rent"`
+	Buy      []watchProvider `json:"buy"`
+}
+
+func (r regionProviders) byType(offerType string) []watchProvider {
+	switch offerType {
+	case "flatrate":
+		return r.Flatrate
+	case "free":
+		return r.Free
+	case "ads":
+		return r.Ads
+	case "rent":
+		return r.Rent
+	case "buy":
+		return r.Buy
+	}
+	return nil
+}
+
+// UseTMDbProviders tells if VOD availability comes from TMDb rather than from the
+// Letterboxd favorite services
+func (s *Scrapping) UseTMDbProviders() bool {
+	return len(s.providers.Ids) > 0
+}
+
+func (s *Scrapping) fetchProviders(film *lxbd.Film) error {
+	var res struct {
+		Results map[string]regionProviders `json:"results"`
+	}
+
+	if err := s.tmdbGet(fmt.Sprintf("/%s/%d/watch/providers", film.MediaType(), film.TmdbId), nil, &res); err != nil {
+		return err
+	}
+
+	film.VODAvailable = false
+	film.VODProviders = nil
+
+	offers := res.Results[s.providers.Region]
+	for _, offerType := range s.providers.Types {
+		for _, provider := range offers.byType(offerType) {
+			if slices.Contains(s.providers.Ids, provider.ProviderId) && !slices.Contains(film.VODProviders, provider.ProviderName) {
+				film.VODAvailable = true
+				film.VODProviders = append(film.VODProviders, provider.ProviderName)
+			}
+		}
+	}
+	return nil
+}
+
+// TMDbCheckProviders sets the VOD availability of the films according to the TMDb watch
+// providers of the configured region
+func (s *Scrapping) TMDbCheckProviders(films []lxbd.Film) {
+	jobs := make(chan *lxbd.Film)
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for film := range jobs {
+				if err := s.fetchProviders(film); err != nil {
+					log.Printf("Failed to get watch providers for lid %d: %s", film.Lid, err)
+					CountError(err)
+				}
+			}
+		}()
+	}
+
+	for i := range films {
+		jobs <- &films[i]
+	}
+	close(jobs)
+	wg.Wait()
+}
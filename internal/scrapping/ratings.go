@@ -0,0 +1,135 @@
+package scrapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocolly/colly"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// ratingsStale tells whether the ratings of the film must be fetched again
+func (s *Scrapping) ratingsStale(film lxbd.Film) bool {
+	if film.Ratings == nil {
+		return true
+	}
+	return s.ratingsTTL > 0 && time.Since(film.Ratings.FetchedAt) > s.ratingsTTL
+}
+
+// the film page describes the film with JSON-LD, wrapped in a CDATA comment
+type filmLinkedData struct {
+	AggregateRating *struct {
+		RatingValue float64 `json:"ratingValue"`
+		RatingCount int     `json:"ratingCount"`
+	} `json:"aggregateRating"`
+}
+
+func (s *Scrapping) onFilmRatings(e *colly.HTMLElement, film *lxbd.Film) {
+	// the script is in the head or the body depending on the page
+	script := e.DOM.Parent().Find(`script[type="application/ld+json"]`).First().Text()
+	start, end := strings.Index(script, "{"), strings.LastIndex(script, "}")
+	if start < 0 || end < start {
+		// the ratings are left as they were, a count of 0 would be taken for a film nobody rated
+		err := classify(ErrLayoutChanged, fmt.Sprintf("no linked data found for lid %d", film.Lid), nil)
+		log.Println(err)
+		CountError(err)
+		return
+	}
+	var data filmLinkedData
+	if err := json.Unmarshal([]byte(script[start:end+1]), &data); err != nil {
+		err = classify(ErrLayoutChanged, fmt.Sprintf("parsing linked data of lid %d", film.Lid), err)
+		log.Println(err)
+		CountError(err)
+		return
+	}
+
+	ratings := lxbd.Ratings{FetchedAt: time.Now()}
+	if film.Ratings != nil {
+		ratings.Fans = film.Ratings.Fans
+		ratings.FriendsAverage = film.Ratings.FriendsAverage
+		ratings.FriendsCount = film.Ratings.FriendsCount
+	}
+	// films with too few ratings have no average
+	if data.AggregateRating != nil {
+		ratings.Average = data.AggregateRating.RatingValue
+		ratings.Count = data.AggregateRating.RatingCount
+	}
+	film.Ratings = &ratings
+
+	// the number of fans is in the rating histogram, loaded separately by the page
+	s.visitFilm(film, lxbdBaseUrl+"/csi"+film.LxbdEndpoint+"rating-histogram/")
+
+	// and the ratings of the friends in the activity of the user's friends on the film
+	if s.lxbdUsername != "" && strings.HasPrefix(film.LxbdEndpoint, "/film/") {
+		s.visitFilm(film, lxbdBaseUrl+"/"+s.lxbdUsername+"/friends"+film.LxbdEndpoint)
+	}
+}
+
+func (s *Scrapping) onRatingHistogram(e *colly.HTMLElement, film *lxbd.Film) {
+	if film.Ratings == nil {
+		return
+	}
+
+	text := strings.TrimSpace(e.DOM.Find(`a[href$="/fans/"]`).First().Text())
+	if text == "" {
+		film.Ratings.Fans = 0
+		return
+	}
+
+	fans, ok := parseCount(strings.TrimSpace(strings.TrimSuffix(text, "fans")))
+	if !ok {
+		log.Printf("Could not convert \"%s\" to a number of fans", text)
+		return
+	}
+	film.Ratings.Fans = fans
+}
+
+// onFriendsRatings averages the star ratings of the friends who rated the film, only the first
+// page of the friends activity is read
+func (s *Scrapping) onFriendsRatings(e *colly.HTMLElement, film *lxbd.Film) {
+	if film.Ratings == nil {
+		return
+	}
+
+	sum, count := 0, 0
+	e.ForEach(".person-table .rating", func(_ int, rating *colly.HTMLElement) {
+		for _, class := range strings.Fields(rating.Attr("class")) {
+			// half stars, from rated-1 to rated-10
+			if n, err := strconv.Atoi(strings.TrimPrefix(class, "rated-")); err == nil && n >= 1 && n <= 10 {
+				sum += n
+				count++
+				return
+			}
+		}
+	})
+
+	film.Ratings.FriendsCount = count
+	film.Ratings.FriendsAverage = 0
+	if count > 0 {
+		film.Ratings.FriendsAverage = float64(sum) / float64(count) / 2
+	}
+}
+
+// parseCount reads the abbreviated numbers displayed by Letterboxd (950, 1.2K, 3M)
+func parseCount(s string) (int, bool) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1e3
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1e6
+	}
+	s = strings.TrimRight(s, "KM")
+	s = strings.ReplaceAll(s, ",", "")
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return int(n*multiplier + 0.5), true
+}
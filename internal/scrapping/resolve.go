@@ -0,0 +1,137 @@
+package scrapping
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// the film being resolved and the attempt number are carried in each colly request context,
+// so that any number of film pages can be visited at the same time
+const (
+	ctxFilm    = "film"
+	ctxAttempt = "attempt"
+)
+
+func (s *Scrapping) onFilmPage(e *colly.HTMLElement) {
+	film, ok := e.Request.Ctx.GetAny(ctxFilm).(*lxbd.Film)
+	if !ok {
+		return
+	}
+
+	if strings.HasPrefix(e.Request.URL.Path, "/csi/") {
+		s.onRatingHistogram(e, film)
+		return
+	}
+	if strings.Contains(e.Request.URL.Path, "/friends/film/") {
+		s.onFriendsRatings(e, film)
+		return
+	}
+
+	// films found by TMDb id are redirected to their page, which gives their Letterboxd id
+	if film.Lid == 0 {
+		lidStr, _ := e.DOM.Parent().Find("[data-film-id]").First().Attr("data-film-id")
+		if lid, err := strconv.Atoi(lidStr); err == nil {
+			film.Lid = lid
+			film.LxbdEndpoint = e.Request.URL.Path
+		}
+	}
+	s.onFilmRatings(e, film)
+
+	tmdbIdStr := e.Attr("data-tmdb-id")
+	tmdbId, err := strconv.Atoi(tmdbIdStr)
+	if err != nil {
+		log.Printf("Could not convert \"%s\" to TMDb id", tmdbIdStr)
+		return
+	}
+
+	film.TmdbId = tmdbId
+	film.TmdbType = lxbd.TMDB_MOVIE
+	if e.Attr("data-tmdb-type") == lxbd.TMDB_TV {
+		film.TmdbType = lxbd.TMDB_TV
+	}
+	log.Printf("Fetched tmdbId %d for lid %d", tmdbId, film.Lid)
+}
+
+func (s *Scrapping) onFilmPageError(r *colly.Response, err error) {
+	attempt, _ := r.Ctx.GetAny(ctxAttempt).(int)
+
+	transient := r.StatusCode == 0 || r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= http.StatusInternalServerError
+	if !transient || attempt >= s.retries {
+		log.Printf("Failed to visit %s (HTTP %d): %s", r.Request.URL, r.StatusCode, err)
+		switch {
+		case r.StatusCode == 0:
+			CountError(classify(ErrNetwork, r.Request.URL.String(), err))
+		case r.StatusCode == http.StatusTooManyRequests:
+			CountError(classify(ErrRateLimited, r.Request.URL.String(), err))
+		default:
+			CountError(err)
+		}
+		return
+	}
+
+	// the limit rule slot is already released here, sleeping only delays this film
+	delay := s.retryBackoff << (attempt - 1)
+	log.Printf("Visiting %s failed (attempt %d/%d), retrying in %s: %s", r.Request.URL, attempt, s.retries, delay, err)
+	time.Sleep(delay)
+
+	r.Ctx.Put(ctxAttempt, attempt+1)
+	if err := r.Request.Retry(); err != nil {
+		log.Printf("Failed to retry %s: %s", r.Request.URL, err)
+	}
+}
+
+// visitFilm queues a page about the film in the collector
+func (s *Scrapping) visitFilm(film *lxbd.Film, url string) {
+	ctx := colly.NewContext()
+	ctx.Put(ctxFilm, film)
+	ctx.Put(ctxAttempt, 1)
+
+	if err := s.Collector.Request(http.MethodGet, url, nil, ctx, nil); err != nil {
+		log.Printf("Failed to visit %s: %s", url, err)
+	}
+}
+
+// visitFilmPages visits the Letterboxd page of the films concurrently to get their TMDb id and
+// ratings, within the collector parallelism and delay limits
+func (s *Scrapping) visitFilmPages(films []*lxbd.Film) {
+	for _, film := range films {
+		s.visitFilm(film, lxbdBaseUrl+film.LxbdEndpoint)
+	}
+	s.Collector.Wait()
+}
+
+// fetchTMDbInfos gets the TMDb info of the films with a resolved TMDb id using a pool of workers
+func (s *Scrapping) fetchTMDbInfos(films []tmdbFetch) {
+	jobs := make(chan tmdbFetch)
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fetch := range jobs {
+				if err := s.fetchTMDbInfo(fetch); err != nil {
+					CountError(err)
+				}
+			}
+		}()
+	}
+
+	for _, fetch := range films {
+		if fetch.film.TmdbId == 0 {
+			log.Printf("No TMDb id found for lid %d", fetch.film.Lid)
+			continue
+		}
+		jobs <- fetch
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -2,8 +2,11 @@ package scrapping
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gocolly/colly"
@@ -11,15 +14,33 @@ import (
 	"github.com/tebeka/selenium"
 	"github.com/tebeka/selenium/chrome"
 
+	c "github.com/alozach/lbxd_seerr/internal/config"
 	"github.com/alozach/lbxd_seerr/internal/lxbd"
+	"github.com/alozach/lbxd_seerr/internal/metrics"
+	"github.com/alozach/lbxd_seerr/internal/tmdbcache"
 )
 
 type Scrapping struct {
-	Collector    *colly.Collector
-	Driver       selenium.WebDriver
-	service      *selenium.Service
-	lxbdUsername string
-	tmdbAPI      *tmdb.TMDb
+	Collector      *colly.Collector
+	Driver         selenium.WebDriver
+	service        *selenium.Service
+	lxbdUsername   string
+	tmdbAPI        *tmdb.TMDb
+	tmdbApiKey     string
+	tmdbLanguage   string
+	tmdbRegions    []string
+	releaseTypes   []int
+	providers      c.TMDbProvidersConfig
+	httpClient     *http.Client
+	TMDbCache      *tmdbcache.Cache
+	pageTimeout    time.Duration
+	elementTimeout time.Duration
+	retries        int
+	retryBackoff   time.Duration
+	concurrency    int
+	requestDelay   time.Duration
+	ratingsTTL     time.Duration
+	fullReadEvery  time.Duration
 }
 
 const lxbdBaseUrl string = "https://letterboxd.com"
@@ -27,11 +48,16 @@ const lxbdBaseUrl string = "https://letterboxd.com"
 func (scrapping *Scrapping) initColly() {
 	scrapping.Collector = colly.NewCollector(
 		colly.Async(true), // Enable asynchronous requests
+		colly.AllowURLRevisit(),
 	)
 	scrapping.Collector.Limit(&colly.LimitRule{
 		DomainGlob:  "*",
-		Parallelism: 6,
+		Parallelism: scrapping.concurrency,
+		Delay:       scrapping.requestDelay,
 	})
+	scrapping.Collector.SetRequestTimeout(scrapping.pageTimeout)
+	scrapping.Collector.OnHTML("body", scrapping.onFilmPage)
+	scrapping.Collector.OnError(scrapping.onFilmPageError)
 }
 
 func (scrapping *Scrapping) initSelenium() {
@@ -58,19 +84,50 @@ func (scrapping *Scrapping) initSelenium() {
 	if err != nil {
 		log.Fatal("Error maximizing window: ", err)
 	}
+
+	err = scrapping.Driver.SetPageLoadTimeout(scrapping.pageTimeout)
+	if err != nil {
+		log.Fatal("Error setting page load timeout: ", err)
+	}
 }
 
-func Init(tmdbApiKey string) *Scrapping {
-	scrapping := &Scrapping{}
+func Init(lxbdConfig c.LxbdConfig, tmdbConfig c.TMDbConfig) *Scrapping {
+	scrapping := &Scrapping{
+		pageTimeout:    lxbdConfig.PageTimeout,
+		elementTimeout: lxbdConfig.ElementTimeout,
+		retries:        lxbdConfig.Retries,
+		retryBackoff:   lxbdConfig.RetryBackoff,
+		concurrency:    lxbdConfig.Concurrency,
+		requestDelay:   lxbdConfig.RequestDelay,
+		ratingsTTL:     lxbdConfig.RatingsTTL,
+		fullReadEvery:  lxbdConfig.WatchedFullRead,
+		tmdbApiKey:     tmdbConfig.ApiKey,
+		tmdbLanguage:   tmdbConfig.Language,
+		tmdbRegions:    tmdbConfig.Regions,
+		httpClient:     &http.Client{Timeout: lxbdConfig.PageTimeout},
+		providers:      tmdbConfig.Providers,
+	}
+	if scrapping.providers.Region == "" {
+		scrapping.providers.Region = tmdbConfig.Regions[0]
+	}
+	for _, name := range tmdbConfig.ReleaseTypes {
+		releaseType, ok := lxbd.ReleaseTypes[name]
+		if !ok {
+			log.Println("Invalid release type: ", name)
+			continue
+		}
+		scrapping.releaseTypes = append(scrapping.releaseTypes, releaseType)
+	}
 	scrapping.initColly()
 	scrapping.initSelenium()
 
 	config := tmdb.Config{
-		APIKey:   tmdbApiKey,
+		APIKey:   tmdbConfig.ApiKey,
 		Proxies:  nil,
 		UseProxy: false,
 	}
 	scrapping.tmdbAPI = tmdb.Init(config)
+	scrapping.TMDbCache = tmdbcache.Load(tmdbConfig.Cache, scrapping.releaseDate)
 
 	return scrapping
 }
@@ -79,52 +136,83 @@ func Deinit(s *Scrapping) {
 	s.service.Stop()
 }
 
-func (scrapping *Scrapping) LxbdAcceptCookies() error {
-	err := scrapping.Driver.Get(lxbdBaseUrl)
+// loadPage opens url in the browser, retrying on network errors and rate limiting
+func (scrapping *Scrapping) loadPage(url string) error {
+	return scrapping.withRetry("loading "+url, func() error {
+		if err := scrapping.Driver.Get(url); err != nil {
+			return driverError("getting page "+url, err)
+		}
+
+		title, err := scrapping.Driver.Title()
+		if err != nil {
+			return driverError("getting page title", err)
+		}
+		if strings.Contains(title, "Too Many Requests") || strings.Contains(title, "Access denied") {
+			return classify(ErrRateLimited, url, errors.New(title))
+		}
+		return nil
+	})
+}
+
+// waitForElement waits until the element is displayed, instead of failing if it is not there yet
+func (scrapping *Scrapping) waitForElement(by string, value string) (selenium.WebElement, error) {
+	var elem selenium.WebElement
+	err := scrapping.Driver.WaitWithTimeout(func(driver selenium.WebDriver) (bool, error) {
+		elem, _ = driver.FindElement(by, value)
+		if elem == nil {
+			return false, nil
+		}
+		displayed, err := elem.IsDisplayed()
+		return err == nil && displayed, nil
+	}, scrapping.elementTimeout)
+
 	if err != nil {
+		return nil, classify(ErrLayoutChanged, fmt.Sprintf("waiting for %s \"%s\"", by, value), err)
+	}
+	return elem, nil
+}
+
+func (scrapping *Scrapping) LxbdAcceptCookies() error {
+	if err := scrapping.loadPage(lxbdBaseUrl); err != nil {
 		log.Println("Error getting page: ", err)
 		return err
 	}
 
-	err = scrapping.Driver.WaitWithTimeout(func(driver selenium.WebDriver) (bool, error) {
-		elem, _ := driver.FindElement(selenium.ByClassName, "fc-cta-consent")
-		if elem != nil {
-			return elem.IsDisplayed()
-		}
-		return false, nil
-	}, 15*time.Second)
-
+	consentButton, err := scrapping.waitForElement(selenium.ByClassName, "fc-cta-consent")
 	if err != nil {
+		// the consent popup is not always shown
 		log.Println("Timeout waiting for consent popup")
 		return nil
 	}
 
-	consentButton, err := scrapping.Driver.FindElement(selenium.ByClassName, "fc-cta-consent")
-	if err != nil {
-		log.Println("Failed to get Consent div: ", err)
+	if err := consentButton.Click(); err != nil {
+		err = driverError("clicking consent button", err)
+		log.Println(err)
 		return err
 	}
-	consentButton.Click()
 
 	log.Println("Cookies accepted")
 	return nil
 }
 
 func (scrapping *Scrapping) LxbdLogIn(username string, password string) error {
-	err := scrapping.Driver.Get(lxbdBaseUrl)
-	if err != nil {
+	if err := scrapping.loadPage(lxbdBaseUrl); err != nil {
 		log.Println("Error getting page: ", err)
 		return err
 	}
 
-	signInButton, err := scrapping.Driver.FindElement(selenium.ByClassName, "sign-in-menu")
+	signInButton, err := scrapping.waitForElement(selenium.ByClassName, "sign-in-menu")
 	if err != nil {
 		log.Println("Failed to get sign in button: ", err)
 		return err
 	}
-	signInButton.Click()
+	if err := signInButton.Click(); err != nil {
+		err = driverError("clicking sign in button", err)
+		log.Println(err)
+		return err
+	}
 
-	formElement, err := scrapping.Driver.FindElement(selenium.ByID, "signin")
+	formElement, err := scrapping.waitForElement(selenium.ByID, "signin")
 	if err != nil {
 		log.Println("Failed to get sign in form: ", err)
 		return err
@@ -132,33 +220,37 @@ func (scrapping *Scrapping) LxbdLogIn(username string, password string) error {
 
 	input, err := formElement.FindElement(selenium.ByName, "username")
 	if err != nil {
-		log.Println("Failed to get username field: ", err)
+		err = driverError("getting username field", err)
+		log.Println(err)
 		return err
 	}
 	input.SendKeys(username)
 
 	input, err = formElement.FindElement(selenium.ByName, "password")
 	if err != nil {
-		log.Println("Failed to get username field: ", err)
+		err = driverError("getting password field", err)
+		log.Println(err)
 		return err
 	}
 	input.SendKeys(password)
 
 	err = formElement.Submit()
 	if err != nil {
-		log.Println("Failed to submit login form: ", err)
+		err = driverError("submitting login form", err)
+		log.Println(err)
 		return err
 	}
 
 	err = scrapping.Driver.WaitWithTimeout(func(driver selenium.WebDriver) (bool, error) {
-		if _, err := driver.GetCookie("letterboxd.signed.in.as"); err != nil {
+		if _, err := driver.GetCookie(lxbdSignedInCookie); err != nil {
 			return false, nil
 		}
 		return true, nil
-	}, 10*time.Second)
+	}, scrapping.elementTimeout)
 
 	if err != nil {
-		log.Println("Timeout waiting logged in cookie")
+		err = classify(ErrLoginFailed, "no signed in cookie after submitting the login form", nil)
+		log.Println(err)
 		return err
 	}
 
@@ -168,75 +260,111 @@ func (scrapping *Scrapping) LxbdLogIn(username string, password string) error {
 	return nil
 }
 
-func (s *Scrapping) fetchTMDbId(film *lxbd.Film) error {
-	s.Collector.OnHTML("body", func(e *colly.HTMLElement) {
-		tmdbIdStr := e.Attr("data-tmdb-id")
-		tmdbId, err := strconv.Atoi(tmdbIdStr)
-		if err != nil {
-			log.Printf("Could not convert \"%s\" to TMDb id", tmdbIdStr)
+// tmdbFetch is a film whose TMDb info is not cached or partly outdated
+type tmdbFetch struct {
+	film  *lxbd.Film
+	entry tmdbcache.Entry
+	stale tmdbcache.Stale
+}
+
+// fetchTMDbInfo fetches the outdated parts of the film TMDb info, keeping the cached ones
+func (s *Scrapping) fetchTMDbInfo(f tmdbFetch) error {
+	film, entry := f.film, f.entry
+	if f.stale.Info {
+		var err error
+		if film.IsTV() {
+			entry.Info, entry.Seasons, err = s.fetchTMDbTVInfo(film)
 		} else {
-			film.TmdbId = tmdbId
-			log.Printf("Fetched tmdbId %d for lid %d", tmdbId, film.Lid)
+			entry.Info, err = s.fetchTMDbMovieInfo(film)
 		}
-	})
-
-	url := lxbdBaseUrl + film.LxbdEndpoint
-	err := s.Collector.Visit(url)
-	defer func() {
-		s.Collector.Wait()
-		s.Collector.OnHTMLDetach("body")
-	}()
+		if err != nil {
+			return err
+		}
+	}
 
-	if err != nil {
-		log.Printf("Failed to visit %s: %s", url, err)
-		return err
+	// series have no release dates
+	if f.stale.ReleaseDates && !film.IsTV() {
+		releaseDates, err := s.fetchReleaseDates(film.TmdbId)
+		if err != nil {
+			log.Printf("Failed to get TMDb release dates for lid %d: %s", film.Lid, err)
+			return err
+		}
+		entry.ReleaseDates = releaseDates
 	}
 
+	s.TMDbCache.Put(film.MediaKey(), entry, f.stale)
+	s.setTMDbInfo(film, entry)
 	return nil
 }
 
-func (s *Scrapping) fetchTMDbInfo(film *lxbd.Film) error {
-	err := s.fetchTMDbId(film)
+func (s *Scrapping) fetchTMDbMovieInfo(film *lxbd.Film) (*tmdb.Movie, error) {
+	start := time.Now()
+	info, err := s.tmdbAPI.GetMovieInfo(film.TmdbId, map[string]string{"language": s.tmdbLanguage})
 	if err != nil {
-		return err
-	}
-	film.TmdbInfo, err = s.tmdbAPI.GetMovieInfo(film.TmdbId, map[string]string{"language": "fr-FR", "append_to_response": "releases"})
-	if err != nil {
-		log.Printf("Failed to get TMDb info for lid %d: %s", film.Lid, err)
-		film.TmdbInfo = nil
-		return errors.New("failed to get TMDb info")
+		metrics.ObserveAPICall("tmdb", start, 0)
+		log.Printf("Failed to get TMDb info for lid %d: %s", film.Lid, stripURL(err))
+		return nil, errors.New("failed to get TMDb info")
 	}
+	metrics.ObserveAPICall("tmdb", start, http.StatusOK)
+	return info, nil
+}
 
-	// fetch FR release date 
-	for _, rel := range film.TmdbInfo.Releases.Countries {
-		if rel.Iso3166_1 == "FR" {
-			film.TmdbInfo.ReleaseDate = rel.ReleaseDate
-			break
-		}
+// lxbdPosters loads a page of the user profile and returns its film posters
+func (scrapping *Scrapping) lxbdPosters(endpoint string) ([]selenium.WebElement, error) {
+	url := lxbdBaseUrl + "/" + scrapping.lxbdUsername + endpoint
+	if err := scrapping.loadPage(url); err != nil {
+		log.Println("Error getting page: ", err)
+		return nil, err
 	}
-	film.TmdbInfo.Releases = nil
-	return nil
-}
 
-func (scrapping *Scrapping) LxbdExtractFilms(endpoint string, previousData []lxbd.Film) ([]lxbd.Film, error) {
-	var films []lxbd.Film
+	// ids are set by a script after the page is loaded, wait for every poster to have one
+	var filmsDiv []selenium.WebElement
+	err := scrapping.Driver.WaitWithTimeout(func(driver selenium.WebDriver) (bool, error) {
+		posters, err := driver.FindElements(selenium.ByClassName, "film-poster")
+		if err != nil || len(posters) == 0 {
+			return false, nil
+		}
+		for _, poster := range posters {
+			if id, err := poster.GetAttribute("data-film-id"); err != nil || id == "" {
+				return false, nil
+			}
+		}
+		filmsDiv = posters
+		return true, nil
+	}, scrapping.elementTimeout)
 
-	url := lxbdBaseUrl + "/" + scrapping.lxbdUsername + endpoint
-	err := scrapping.Driver.Get(url)
 	if err != nil {
-		log.Println("Error getting page: ", err)
+		// an empty list still has its poster container, posters without id are a page not fully loaded
+		// which must not be taken for an empty list
+		posters, _ := scrapping.Driver.FindElements(selenium.ByClassName, "film-poster")
+		if _, err := scrapping.Driver.FindElement(selenium.ByClassName, "poster-list"); err == nil && len(posters) == 0 {
+			log.Printf("No films found in %s", endpoint)
+			return nil, nil
+		}
+		if len(posters) > 0 {
+			err = classify(ErrLayoutChanged, fmt.Sprintf("film posters without id in %s", endpoint), nil)
+		} else {
+			err = classify(ErrLayoutChanged, "no film posters found in "+endpoint, nil)
+		}
+		log.Println(err)
 		return nil, err
 	}
 
-	// ids can take some time to appear
-	time.Sleep(1 * time.Second)
+	return filmsDiv, nil
+}
 
-	filmsDiv, err := scrapping.Driver.FindElements(selenium.ByClassName, "film-poster")
+// LxbdExtractFilms returns the films of a list with their TMDb info. firstSeen, if not nil, dates the
+// films of the list, including the ones whose TMDb info could not be fetched
+func (scrapping *Scrapping) LxbdExtractFilms(endpoint string, previousData []lxbd.Film, firstSeen *lxbd.FirstSeen) ([]lxbd.Film, error) {
+	var films []lxbd.Film
+
+	filmsDiv, err := scrapping.lxbdPosters(endpoint)
 	if err != nil {
-		log.Println("Failed to get films: ", err)
 		return nil, err
 	}
 
+	var toFetch []tmdbFetch
+	var toVisit []*lxbd.Film
 	for _, div := range filmsDiv {
 		name, err := div.GetAttribute("data-film-slug")
 		if err != nil {
@@ -267,23 +395,68 @@ func (scrapping *Scrapping) LxbdExtractFilms(endpoint string, previousData []lxb
 		for _, prev := range previousData {
 			if lid == prev.Lid {
 				film = &prev
-				log.Printf("Using previously fetched tmdbInfo for lid %d", lid)
+				log.Printf("Using previously fetched tmdbId for lid %d", lid)
 			}
 		}
 
 		if film == nil {
 			film = &lxbd.Film{Lid: lid, LxbdEndpoint: link}
 		}
+		// a film previously added from a collection may have been added to the list since
+		film.ExpandedFrom = nil
 
-		if film.TmdbInfo == nil {
-			if err := scrapping.fetchTMDbInfo(film); err != nil {
-				continue
-			}
+		films = append(films, *film)
+	}
+	if firstSeen != nil {
+		firstSeen.Track(films)
+	}
+
+	// films must not be appended to anymore, the fetch workers write through pointers to its elements
+	var untyped []*lxbd.Film
+	for i := range films {
+		if films[i].TmdbId != 0 && !films[i].TypeKnown() {
+			untyped = append(untyped, &films[i])
+		}
+		films[i].TmdbInfo = nil
+		if films[i].TmdbId == 0 || !films[i].TypeKnown() || scrapping.ratingsStale(films[i]) {
+			toVisit = append(toVisit, &films[i])
 		}
+	}
+	if len(toVisit) > 0 {
+		log.Printf("Visiting the Letterboxd page of %d films", len(toVisit))
+		scrapping.visitFilmPages(toVisit)
+	}
+	for _, film := range untyped {
+		// series resolved before they were handled had their info cached as a movie
+		if film.IsTV() {
+			scrapping.TMDbCache.Delete(lxbd.MediaKey(lxbd.TMDB_MOVIE, film.TmdbId))
+		}
+	}
 
-		films = append(films, *film)
+	// the cache is looked up once the TMDb id and type of the new films are known
+	for i := range films {
+		if fetch := scrapping.cachedTMDbInfo(&films[i]); fetch != nil {
+			toFetch = append(toFetch, *fetch)
+		}
+	}
+	if len(toFetch) > 0 {
+		log.Printf("Fetching TMDb info of %d films", len(toFetch))
+		scrapping.fetchTMDbInfos(toFetch)
+	}
 
+	var fetched []lxbd.Film
+	for _, film := range films {
+		if film.TmdbInfo != nil {
+			fetched = append(fetched, film)
+		}
 	}
+	return fetched, nil
+}
 
-	return films, nil
+// CheckBrowser checks that the Selenium session is still usable
+func (scrapping *Scrapping) CheckBrowser() error {
+	if _, err := scrapping.Driver.CurrentURL(); err != nil {
+		return driverError("Selenium session", err)
+	}
+	return nil
 }
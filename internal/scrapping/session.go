@@ -0,0 +1,149 @@
+package scrapping
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tebeka/selenium"
+)
+
+const sessionFilename = "/app/data/lxbd_session.bin"
+
+const lxbdSignedInCookie = "letterboxd.signed.in.as"
+
+// session cookies are encrypted with a key derived from the account password,
+// so that the file is useless without the config
+func sessionCipher(password string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("lbxd_seerr session:" + password))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (scrapping *Scrapping) LxbdSaveSession(password string) error {
+	cookies, err := scrapping.Driver.GetCookies()
+	if err != nil {
+		log.Println("Failed to get browser cookies: ", err)
+		return err
+	}
+
+	plain, err := json.Marshal(cookies)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := sessionCipher(password)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := gcm.Seal(nonce, nonce, plain, nil)
+
+	if err := os.MkdirAll(filepath.Dir(sessionFilename), os.ModePerm); err != nil {
+		log.Println("Failed to save session: ", err)
+		return err
+	}
+	if err := os.WriteFile(sessionFilename, data, 0600); err != nil {
+		log.Println("Failed to save session: ", err)
+		return err
+	}
+
+	log.Printf("Saved %d session cookies", len(cookies))
+	return nil
+}
+
+func loadSessionCookies(password string) ([]selenium.Cookie, error) {
+	data, err := os.ReadFile(sessionFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := sessionCipher(password)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("session file is corrupted")
+	}
+	nonce, cipherText := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, cipherText, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt session file")
+	}
+
+	var cookies []selenium.Cookie
+	if err := json.Unmarshal(plain, &cookies); err != nil {
+		return nil, err
+	}
+	return cookies, nil
+}
+
+// LxbdRestoreSession loads the cookies saved by LxbdSaveSession into the browser
+// and checks that Letterboxd still considers us signed in
+func (scrapping *Scrapping) LxbdRestoreSession(username string, password string) error {
+	cookies, err := loadSessionCookies(password)
+	if err != nil {
+		log.Println("No usable saved session: ", err)
+		return err
+	}
+
+	now := uint(time.Now().Unix())
+	signedIn := false
+	for _, c := range cookies {
+		if c.Name == lxbdSignedInCookie && (c.Expiry == 0 || c.Expiry > now) {
+			signedIn = true
+		}
+	}
+	if !signedIn {
+		log.Println("Saved session has expired")
+		return errors.New("saved session expired")
+	}
+
+	// cookies can only be set for the domain currently loaded
+	if err := scrapping.loadPage(lxbdBaseUrl); err != nil {
+		log.Println("Error getting page: ", err)
+		return err
+	}
+
+	for i := range cookies {
+		if cookies[i].Expiry != 0 && cookies[i].Expiry <= now {
+			continue
+		}
+		if err := scrapping.Driver.AddCookie(&cookies[i]); err != nil {
+			log.Printf("Failed to restore cookie %s: %s", cookies[i].Name, err)
+		}
+	}
+
+	// load the page again with the restored cookies
+	if err := scrapping.loadPage(lxbdBaseUrl); err != nil {
+		log.Println("Error getting page: ", err)
+		return err
+	}
+
+	// Letterboxd drops the signed in cookie if the session is not valid anymore
+	c, err := scrapping.Driver.GetCookie(lxbdSignedInCookie)
+	if err != nil || c.Value == "" {
+		log.Println("Saved session is not valid anymore")
+		scrapping.Driver.DeleteAllCookies()
+		return errors.New("saved session invalid")
+	}
+
+	log.Println("Restored previous Letterboxd session")
+
+	scrapping.lxbdUsername = username
+	return nil
+}
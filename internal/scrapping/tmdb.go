@@ -0,0 +1,206 @@
+package scrapping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/ryanbradynd05/go-tmdb"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+	"github.com/alozach/lbxd_seerr/internal/metrics"
+	"github.com/alozach/lbxd_seerr/internal/tmdbcache"
+)
+
+// endpoints not covered by the go-tmdb package are called directly
+const tmdbBaseUrl string = "https://api.themoviedb.org/3"
+
+// stripURL removes the request url from HTTP client errors, as TMDb urls hold the API key which must
+// not end up in the logs nor in /readyz
+func stripURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+func (s *Scrapping) tmdbGetOnce(endpoint string, params url.Values, result interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("api_key", s.tmdbApiKey)
+	requestUrl := tmdbBaseUrl + endpoint + "?" + params.Encode()
+
+	start := time.Now()
+	res, err := s.httpClient.Get(requestUrl)
+	if err != nil {
+		metrics.ObserveAPICall("tmdb", start, 0)
+		return classify(ErrNetwork, "TMDb "+endpoint, stripURL(err))
+	}
+	defer res.Body.Close()
+	metrics.ObserveAPICall("tmdb", start, res.StatusCode)
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return classify(ErrRateLimited, "TMDb "+endpoint, nil)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("TMDb %s: got HTTP code %d", endpoint, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+func (s *Scrapping) tmdbGet(endpoint string, params url.Values, result interface{}) error {
+	return s.withRetry("TMDb "+endpoint, func() error {
+		return s.tmdbGetOnce(endpoint, params, result)
+	})
+}
+
+func (s *Scrapping) fetchReleaseDates(tmdbId int) ([]lxbd.ReleaseDate, error) {
+	var res struct {
+		Results []struct {
+			Iso3166_1    string `json:"iso_3166_1"`
+			ReleaseDates []struct {
+				Type        int    `json:"type"`
+				ReleaseDate string `json:"release_date"`
+			} `json:"release_dates"`
+		} `json:"results"`
+	}
+
+	if err := s.tmdbGet(fmt.Sprintf("/movie/%d/release_dates", tmdbId), nil, &res); err != nil {
+		return nil, err
+	}
+
+	var dates []lxbd.ReleaseDate
+	for _, country := range res.Results {
+		for _, rel := range country.ReleaseDates {
+			// dates are given as 2019-03-06T00:00:00.000Z
+			if len(rel.ReleaseDate) < len("2006-01-02") {
+				continue
+			}
+			dates = append(dates, lxbd.ReleaseDate{Country: country.Iso3166_1, Type: rel.Type, Date: rel.ReleaseDate[:len("2006-01-02")]})
+		}
+	}
+	return dates, nil
+}
+
+// selectRelease picks the earliest release of the configured types, in the first
+// region of the fallback chain having one
+func (s *Scrapping) selectRelease(dates []lxbd.ReleaseDate) *lxbd.ReleaseDate {
+	for _, region := range s.tmdbRegions {
+		var selected *lxbd.ReleaseDate
+		for i, d := range dates {
+			if d.Country != region || !slices.Contains(s.releaseTypes, d.Type) {
+				continue
+			}
+			if selected == nil || d.Date < selected.Date {
+				selected = &dates[i]
+			}
+		}
+
+		if selected != nil {
+			release := *selected
+			return &release
+		}
+	}
+	return nil
+}
+
+// releaseDate is the date a cached film is considered released, as selected by setTMDbInfo
+func (s *Scrapping) releaseDate(entry tmdbcache.Entry) string {
+	if release := s.selectRelease(entry.ReleaseDates); release != nil {
+		return release.Date
+	}
+	return entry.Info.ReleaseDate
+}
+
+// cachedTMDbInfo sets the TMDb info of the film from the cache, or returns what has to be fetched
+func (s *Scrapping) cachedTMDbInfo(film *lxbd.Film) *tmdbFetch {
+	if film.TmdbId == 0 {
+		// the Letterboxd page could not be visited
+		return &tmdbFetch{film: film, stale: tmdbcache.All}
+	}
+
+	entry, stale, ok := s.TMDbCache.Get(film.MediaKey())
+	if ok && !stale.Any() {
+		s.setTMDbInfo(film, entry)
+		return nil
+	}
+	return &tmdbFetch{film: film, entry: entry, stale: stale}
+}
+
+func (s *Scrapping) setTMDbInfo(film *lxbd.Film, entry tmdbcache.Entry) {
+	film.TmdbInfo = entry.Info
+	film.Seasons = entry.Seasons
+	film.Release = s.selectRelease(entry.ReleaseDates)
+	if film.IsTV() {
+		// series only have a first air date
+		return
+	}
+	if film.Release != nil {
+		film.TmdbInfo.ReleaseDate = film.Release.Date
+	} else {
+		log.Printf("No release in %v for lid %d, using primary release date", s.tmdbRegions, film.Lid)
+	}
+}
+
+// fetchTMDbTVInfo gets the info of a series, kept in a tmdb.Movie like the films info, and its seasons
+func (s *Scrapping) fetchTMDbTVInfo(film *lxbd.Film) (*tmdb.Movie, []lxbd.Season, error) {
+	var res struct {
+		ID            int      `json:"id"`
+		Name          string   `json:"name"`
+		OriginalName  string   `json:"original_name"`
+		FirstAirDate  string   `json:"first_air_date"`
+		Overview      string   `json:"overview"`
+		PosterPath    string   `json:"poster_path"`
+		BackdropPath  string   `json:"backdrop_path"`
+		Popularity    float32  `json:"popularity"`
+		VoteAverage   float32  `json:"vote_average"`
+		VoteCount     uint32   `json:"vote_count"`
+		OriginCountry []string `json:"origin_country"`
+		Seasons       []struct {
+			SeasonNumber int    `json:"season_number"`
+			AirDate      string `json:"air_date"`
+		} `json:"seasons"`
+	}
+
+	params := url.Values{"language": {s.tmdbLanguage}}
+	if err := s.tmdbGet(fmt.Sprintf("/tv/%d", film.TmdbId), params, &res); err != nil {
+		log.Printf("Failed to get TMDb series info for lid %d: %s", film.Lid, err)
+		return nil, nil, err
+	}
+
+	info := &tmdb.Movie{
+		ID:            res.ID,
+		Title:         res.Name,
+		OriginalTitle: res.OriginalName,
+		ReleaseDate:   res.FirstAirDate,
+		Overview:      res.Overview,
+		PosterPath:    res.PosterPath,
+		BackdropPath:  res.BackdropPath,
+		Popularity:    res.Popularity,
+		VoteAverage:   res.VoteAverage,
+		VoteCount:     res.VoteCount,
+	}
+	// not nil for the cache to tell series without seasons from entries saved without them
+	seasons := []lxbd.Season{}
+	for _, season := range res.Seasons {
+		// season 0 holds the specials
+		if season.SeasonNumber > 0 {
+			seasons = append(seasons, lxbd.Season{Number: season.SeasonNumber, AirDate: season.AirDate})
+		}
+	}
+	return info, seasons, nil
+}
+
+// CheckTMDb checks that TMDb is reachable and accepts the API key
+func (s *Scrapping) CheckTMDb() error {
+	var res struct{}
+	return s.tmdbGetOnce("/configuration", nil, &res)
+}
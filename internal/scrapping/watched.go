@@ -0,0 +1,79 @@
+package scrapping
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+// LxbdExtractWatched returns the ids of the films the user logged as watched. The list is read
+// from the most recently watched film and stops at the first page without new film, known
+// being the previously extracted ids. The whole list is read again once in a while, as films
+// logged with an older date and films removed from the list are not seen otherwise
+func (scrapping *Scrapping) LxbdExtractWatched(saved lxbd.Watched) (lxbd.Watched, error) {
+	return readWatched(saved, scrapping.fullReadEvery, time.Now(), func(page int) ([]int, error) {
+		posters, err := scrapping.lxbdPosters(fmt.Sprintf("/films/by/date/page/%d/", page))
+		if err != nil {
+			return nil, err
+		}
+
+		lids := make([]int, 0, len(posters))
+		for _, poster := range posters {
+			lidStr, err := poster.GetAttribute("data-film-id")
+			if err != nil {
+				continue
+			}
+			lid, err := strconv.Atoi(lidStr)
+			if err != nil {
+				log.Printf("Could not convert \"%s\" to LID", lidStr)
+				continue
+			}
+			lids = append(lids, lid)
+		}
+		return lids, nil
+	})
+}
+
+// readWatched reads the pages of the watched list with readPage, which returns the ids of the
+// films of a page, none after the last one
+func readWatched(saved lxbd.Watched, fullReadEvery time.Duration, now time.Time, readPage func(page int) ([]int, error)) (lxbd.Watched, error) {
+	full := now.Sub(saved.FullReadAt) > fullReadEvery
+	if full {
+		log.Println("Reading the whole watched list")
+		saved = lxbd.Watched{FullReadAt: now}
+	}
+
+	knownLids := make(map[int]bool, len(saved.Lids))
+	for _, lid := range saved.Lids {
+		knownLids[lid] = true
+	}
+
+	watched := lxbd.Watched{Lids: append([]int{}, saved.Lids...), FullReadAt: saved.FullReadAt}
+	nbNew := 0
+	for page := 1; ; page++ {
+		lids, err := readPage(page)
+		if err != nil {
+			return lxbd.Watched{}, err
+		}
+
+		pageNew := 0
+		for _, lid := range lids {
+			if !knownLids[lid] {
+				knownLids[lid] = true
+				watched.Lids = append(watched.Lids, lid)
+				pageNew++
+			}
+		}
+		nbNew += pageNew
+
+		if len(lids) == 0 || (pageNew == 0 && !full) {
+			break
+		}
+	}
+
+	log.Printf("Got %d newly watched films, %d in total", nbNew, len(watched.Lids))
+	return watched, nil
+}
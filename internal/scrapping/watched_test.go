@@ -0,0 +1,84 @@
+package scrapping
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+func TestReadWatched(t *testing.T) {
+	now := time.Now()
+	fullReadEvery := 7 * 24 * time.Hour
+	// most recently watched first, 4 and 5 watched since the last read and 9 removed from the list
+	pages := [][]int{{5, 4, 3}, {2, 1}, {7, 6}}
+
+	tests := []struct {
+		name      string
+		saved     lxbd.Watched
+		wantLids  []int
+		wantPages int
+		wantFull  time.Time
+	}{
+		{
+			name:      "first read",
+			wantLids:  []int{5, 4, 3, 2, 1, 7, 6},
+			wantPages: 4,
+			wantFull:  now,
+		},
+		{
+			name:      "stops at the first page without new film",
+			saved:     lxbd.Watched{Lids: []int{3, 2, 1, 7, 6, 9}, FullReadAt: now.Add(-24 * time.Hour)},
+			wantLids:  []int{3, 2, 1, 7, 6, 9, 5, 4},
+			wantPages: 2,
+			wantFull:  now.Add(-24 * time.Hour),
+		},
+		{
+			name:      "full read due",
+			saved:     lxbd.Watched{Lids: []int{3, 2, 1, 7, 6, 9}, FullReadAt: now.Add(-8 * 24 * time.Hour)},
+			wantLids:  []int{5, 4, 3, 2, 1, 7, 6},
+			wantPages: 4,
+			wantFull:  now,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read := 0
+			watched, err := readWatched(tt.saved, fullReadEvery, now, func(page int) ([]int, error) {
+				read++
+				if page > len(pages) {
+					return nil, nil
+				}
+				return pages[page-1], nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(watched.Lids, tt.wantLids) {
+				t.Errorf("got %v, want %v", watched.Lids, tt.wantLids)
+			}
+			if read != tt.wantPages {
+				t.Errorf("read %d pages, want %d", read, tt.wantPages)
+			}
+			if !watched.FullReadAt.Equal(tt.wantFull) {
+				t.Errorf("got full read at %s, want %s", watched.FullReadAt, tt.wantFull)
+			}
+		})
+	}
+}
+
+func TestReadWatchedError(t *testing.T) {
+	saved := lxbd.Watched{Lids: []int{1}, FullReadAt: time.Now()}
+	_, err := readWatched(saved, time.Hour, time.Now(), func(page int) ([]int, error) {
+		if page == 2 {
+			return nil, ErrLayoutChanged
+		}
+		return []int{2}, nil
+	})
+	if !errors.Is(err, ErrLayoutChanged) {
+		t.Errorf("got %v", err)
+	}
+}
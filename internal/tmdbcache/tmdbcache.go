@@ -0,0 +1,303 @@
+package tmdbcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryanbradynd05/go-tmdb"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+	"github.com/alozach/lbxd_seerr/internal/lxbd"
+)
+
+type Entry struct {
+	Info         *tmdb.Movie        `json:"info"`
+	ReleaseDates []lxbd.ReleaseDate `json:"release_dates"`
+	Seasons      []lxbd.Season      `json:"tv_seasons,omitempty"`
+	// FetchedAt is the time Info was fetched, release dates are fetched separately
+	FetchedAt             time.Time `json:"fetched_at"`
+	ReleaseDatesFetchedAt time.Time `json:"release_dates_fetched_at"`
+	UsedAt                time.Time `json:"used_at"`
+}
+
+// Collection is the list of movies of a TMDb collection, as returned by TMDb
+type Collection struct {
+	Parts     []CollectionPart `json:"parts"`
+	FetchedAt time.Time        `json:"fetched_at"`
+	UsedAt    time.Time        `json:"used_at"`
+}
+
+type CollectionPart struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	ReleaseDate string `json:"release_date"`
+}
+
+// Stale tells which parts of an entry have to be fetched again
+type Stale struct {
+	Info         bool
+	ReleaseDates bool
+}
+
+func (s Stale) Any() bool {
+	return s.Info || s.ReleaseDates
+}
+
+// All is used for entries not cached yet
+var All = Stale{Info: true, ReleaseDates: true}
+
+type Stats struct {
+	Hits      int `json:"hits"`
+	Misses    int `json:"misses"`
+	Refreshes int `json:"refreshes"`
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d hits, %d misses, %d refreshes", s.Hits, s.Misses, s.Refreshes)
+}
+
+// Cache keeps the TMDb info of films by media key (see lxbd.MediaKey), refreshing fields that may have
+// changed since they were fetched
+type Cache struct {
+	mu          sync.Mutex
+	entries     map[string]Entry
+	collections map[int]Collection
+	config      c.TMDbCacheConfig
+	stats       Stats
+	// with force_refresh, entries fetched before the current run started are refreshed
+	runStart time.Time
+	// a film may be looked up several times in a run, it is only counted in the stats once
+	counted map[string]bool
+	// releaseDate returns the date the film is considered released, which depends on the configured regions
+	releaseDate func(Entry) string
+}
+
+const cacheFilename = "/app/data/tmdb_cache.txt"
+
+// collections are kept in their own file, the cache file being a map of films
+const collectionsFilename = "/app/data/tmdb_collections.txt"
+
+func Load(config c.TMDbCacheConfig, releaseDate func(Entry) string) *Cache {
+	cache := &Cache{entries: map[string]Entry{}, collections: map[int]Collection{}, config: config, counted: map[string]bool{}, releaseDate: releaseDate}
+
+	if data, err := os.ReadFile(collectionsFilename); err == nil {
+		if err := json.Unmarshal(data, &cache.collections); err != nil {
+			log.Println("Failed to read TMDb collections cache, starting with an empty one: ", err)
+			cache.collections = map[int]Collection{}
+		}
+	}
+
+	file, err := os.Open(cacheFilename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Failed to open TMDb cache: ", err)
+		}
+		return cache
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&cache.entries); err != nil {
+		log.Println("Failed to read TMDb cache, starting with an empty one: ", err)
+		cache.entries = map[string]Entry{}
+	}
+
+	// entries saved before the release dates, last use and season air dates were tracked
+	now := time.Now()
+	for key, e := range cache.entries {
+		if strings.HasPrefix(key, lxbd.TMDB_TV+"/") && e.Seasons == nil {
+			e.FetchedAt = time.Time{}
+		}
+		if e.ReleaseDatesFetchedAt.IsZero() {
+			e.ReleaseDatesFetchedAt = e.FetchedAt
+		}
+		if e.UsedAt.IsZero() {
+			e.UsedAt = now
+		}
+		cache.entries[key] = e
+	}
+	return cache
+}
+
+func (cache *Cache) Save() error {
+	cache.mu.Lock()
+	cache.evict(time.Now())
+	jsonData, err := json.Marshal(cache.entries)
+	if err != nil {
+		cache.mu.Unlock()
+		return err
+	}
+	collectionsData, err := json.Marshal(cache.collections)
+	cache.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cacheFilename), os.ModePerm); err != nil {
+		log.Println("Failed to save TMDb cache: ", err)
+		return err
+	}
+
+	if err := os.WriteFile(cacheFilename, jsonData, 0644); err != nil {
+		log.Println("Failed to save TMDb cache: ", err)
+		return err
+	}
+	if err := os.WriteFile(collectionsFilename, collectionsData, 0644); err != nil {
+		log.Println("Failed to save TMDb collections cache: ", err)
+		return err
+	}
+	return nil
+}
+
+// evict drops the entries of films not looked up for a while, e.g. removed from the watchlist
+func (cache *Cache) evict(now time.Time) {
+	if cache.config.EvictAfter <= 0 {
+		return
+	}
+	for key, e := range cache.entries {
+		if now.Sub(e.UsedAt) > cache.config.EvictAfter {
+			delete(cache.entries, key)
+		}
+	}
+	for id, col := range cache.collections {
+		if now.Sub(col.UsedAt) > cache.config.EvictAfter {
+			delete(cache.collections, id)
+		}
+	}
+}
+
+// stale tells which parts of the entry have to be fetched again: release dates of unreleased films
+// and revenues do not have the same lifetime
+func (cache *Cache) stale(key string, e Entry, now time.Time) Stale {
+	var stale Stale
+
+	releaseDate, err := time.Parse("2006-01-02", cache.releaseDate(e))
+	unreleased := err != nil || releaseDate.After(now)
+	// series have no release dates, their first air date is part of the info
+	if strings.HasPrefix(key, lxbd.TMDB_TV+"/") {
+		stale.Info = unreleased && now.Sub(e.FetchedAt) > cache.config.ReleaseDateTTL
+	} else {
+		stale.ReleaseDates = unreleased && now.Sub(e.ReleaseDatesFetchedAt) > cache.config.ReleaseDateTTL
+	}
+
+	if now.Sub(e.FetchedAt) > cache.config.RevenueTTL {
+		stale.Info = true
+	}
+	return stale
+}
+
+// count updates the stats the first time a film is looked up in the run
+func (cache *Cache) count(key string, stat *int) {
+	if cache.counted[key] {
+		return
+	}
+	cache.counted[key] = true
+	*stat++
+}
+
+// Get returns the cached entry of a film and its parts to fetch again, false if it is not cached
+func (cache *Cache) Get(key string) (Entry, Stale, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	e, ok := cache.entries[key]
+	if !ok || e.Info == nil {
+		cache.count(key, &cache.stats.Misses)
+		return Entry{}, All, false
+	}
+	e.UsedAt = time.Now()
+	cache.entries[key] = e
+
+	info := *e.Info
+	e.Info = &info
+
+	if cache.config.ForceRefresh && e.FetchedAt.Before(cache.runStart) {
+		cache.count(key, &cache.stats.Refreshes)
+		return e, All, true
+	}
+
+	if stale := cache.stale(key, e, time.Now()); stale.Any() {
+		log.Printf("Refreshing TMDb info of %s (%+v)", key, stale)
+		cache.count(key, &cache.stats.Refreshes)
+		return e, stale, true
+	}
+
+	cache.count(key, &cache.stats.Hits)
+	return e, Stale{}, true
+}
+
+// Put saves an entry, refreshed tells which of its parts were just fetched
+func (cache *Cache) Put(key string, e Entry, refreshed Stale) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	info := *e.Info
+	e.Info = &info
+	now := time.Now()
+	if refreshed.Info {
+		e.FetchedAt = now
+	}
+	if refreshed.ReleaseDates {
+		e.ReleaseDatesFetchedAt = now
+	}
+	e.UsedAt = now
+	cache.entries[key] = e
+}
+
+// GetCollection returns the movies of a collection, false if they have to be fetched. New movies are
+// rarely added to collections, the list is kept as long as the revenues
+func (cache *Cache) GetCollection(id int) ([]CollectionPart, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	col, ok := cache.collections[id]
+	if !ok {
+		return nil, false
+	}
+	col.UsedAt = time.Now()
+	cache.collections[id] = col
+
+	if cache.config.ForceRefresh && col.FetchedAt.Before(cache.runStart) {
+		return nil, false
+	}
+	if time.Since(col.FetchedAt) > cache.config.RevenueTTL {
+		return nil, false
+	}
+	return slices.Clone(col.Parts), true
+}
+
+func (cache *Cache) PutCollection(id int, parts []CollectionPart) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	cache.collections[id] = Collection{Parts: slices.Clone(parts), FetchedAt: now, UsedAt: now}
+}
+
+// Delete drops the entry of a film, e.g. cached under the wrong media type
+func (cache *Cache) Delete(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	delete(cache.entries, key)
+}
+
+func (cache *Cache) Stats() Stats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.stats
+}
+
+// StartRun resets the statistics at the beginning of a task run
+func (cache *Cache) StartRun() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.stats = Stats{}
+	cache.counted = map[string]bool{}
+	cache.runStart = time.Now()
+}
@@ -0,0 +1,177 @@
+package tmdbcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ryanbradynd05/go-tmdb"
+
+	c "github.com/alozach/lbxd_seerr/internal/config"
+)
+
+var testConfig = c.TMDbCacheConfig{ReleaseDateTTL: 24 * time.Hour, RevenueTTL: 30 * 24 * time.Hour, EvictAfter: 90 * 24 * time.Hour}
+
+// newCache holds the entries without reading the data folder, films being released on their info release date
+func newCache(config c.TMDbCacheConfig, entries map[string]Entry) *Cache {
+	return &Cache{
+		entries:     entries,
+		collections: map[int]Collection{},
+		config:      config,
+		counted:     map[string]bool{},
+		releaseDate: func(e Entry) string { return e.Info.ReleaseDate },
+	}
+}
+
+func entry(releaseDate string, fetchedAgo, releaseDatesFetchedAgo time.Duration) Entry {
+	now := time.Now()
+	return Entry{
+		Info:                  &tmdb.Movie{ReleaseDate: releaseDate},
+		FetchedAt:             now.Add(-fetchedAgo),
+		ReleaseDatesFetchedAt: now.Add(-releaseDatesFetchedAgo),
+		UsedAt:                now,
+	}
+}
+
+func TestStale(t *testing.T) {
+	day := 24 * time.Hour
+	released := "2020-01-01"
+	unreleased := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+
+	tests := []struct {
+		name  string
+		key   string
+		entry Entry
+		want  Stale
+	}{
+		{name: "fresh released movie", key: "603", entry: entry(released, day, day), want: Stale{}},
+		{name: "released movie past the release date ttl", key: "603", entry: entry(released, 2*day, 2*day), want: Stale{}},
+		{name: "fresh unreleased movie", key: "603", entry: entry(unreleased, 2*day, time.Hour), want: Stale{}},
+		{name: "unreleased movie past the release date ttl", key: "603", entry: entry(unreleased, time.Hour, 2*day), want: Stale{ReleaseDates: true}},
+		{name: "movie without release date", key: "603", entry: entry("", time.Hour, 2*day), want: Stale{ReleaseDates: true}},
+		{name: "movie past the revenue ttl", key: "603", entry: entry(released, 31*day, time.Hour), want: Stale{Info: true}},
+		{name: "unreleased series past the release date ttl", key: "tv/1399", entry: entry(unreleased, 2*day, 2*day), want: Stale{Info: true}},
+		{name: "fresh unreleased series", key: "tv/1399", entry: entry(unreleased, time.Hour, 2*day), want: Stale{}},
+		{name: "released series", key: "tv/1399", entry: entry(released, 2*day, 2*day), want: Stale{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newCache(testConfig, map[string]Entry{tt.key: tt.entry})
+			if got := cache.stale(tt.key, tt.entry, time.Now()); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if _, got, ok := cache.Get(tt.key); !ok || got != tt.want {
+				t.Errorf("Get: got %+v, %t, want %+v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	day := 24 * time.Hour
+	cache := newCache(testConfig, map[string]Entry{
+		"603": entry("2020-01-01", day, day),
+		"604": entry("2020-01-01", 31*day, day),
+	})
+	cache.StartRun()
+
+	// films looked up several times in a run are counted once
+	for i := 0; i < 2; i++ {
+		cache.Get("603")
+		cache.Get("604")
+		if _, stale, ok := cache.Get("605"); ok || stale != All {
+			t.Errorf("got %+v, %t for a missing entry", stale, ok)
+		}
+	}
+	if got, want := cache.Stats(), (Stats{Hits: 1, Misses: 1, Refreshes: 1}); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	cache.Put("604", entry("2020-01-01", 0, 0), Stale{Info: true})
+	cache.StartRun()
+	if _, stale, _ := cache.Get("604"); stale.Any() {
+		t.Errorf("got %+v after a refresh", stale)
+	}
+	if got, want := cache.Stats(), (Stats{Hits: 1}); got != want {
+		t.Errorf("got %s after a new run, want %s", got, want)
+	}
+}
+
+func TestForceRefresh(t *testing.T) {
+	config := testConfig
+	config.ForceRefresh = true
+	cache := newCache(config, map[string]Entry{"603": entry("2020-01-01", time.Hour, time.Hour)})
+	cache.StartRun()
+
+	if _, stale, _ := cache.Get("603"); stale != All {
+		t.Errorf("got %+v for an entry fetched before the run", stale)
+	}
+	// the entries fetched during the run are not fetched again
+	cache.Put("603", entry("2020-01-01", 0, 0), All)
+	if _, stale, _ := cache.Get("603"); stale.Any() {
+		t.Errorf("got %+v for an entry fetched during the run", stale)
+	}
+}
+
+func TestPut(t *testing.T) {
+	cache := newCache(testConfig, map[string]Entry{})
+	old := time.Now().Add(-time.Hour)
+	e := Entry{Info: &tmdb.Movie{}, FetchedAt: old, ReleaseDatesFetchedAt: old}
+
+	// only the parts just fetched get a new date
+	cache.Put("603", e, Stale{ReleaseDates: true})
+	got := cache.entries["603"]
+	if !got.FetchedAt.Equal(old) || !got.ReleaseDatesFetchedAt.After(old) {
+		t.Errorf("got info fetched at %s, release dates at %s", got.FetchedAt, got.ReleaseDatesFetchedAt)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	now := time.Now()
+	usedAt := func(ago time.Duration) Entry {
+		return Entry{Info: &tmdb.Movie{}, UsedAt: now.Add(-ago)}
+	}
+	cache := newCache(testConfig, map[string]Entry{
+		"603":     usedAt(24 * time.Hour),
+		"604":     usedAt(91 * 24 * time.Hour),
+		"tv/1399": usedAt(91 * 24 * time.Hour),
+	})
+	cache.collections = map[int]Collection{
+		2344: {UsedAt: now.Add(-24 * time.Hour)},
+		2345: {UsedAt: now.Add(-91 * 24 * time.Hour)},
+	}
+
+	cache.evict(now)
+	if len(cache.entries) != 1 || cache.entries["603"].Info == nil {
+		t.Errorf("got entries %v", cache.entries)
+	}
+	if _, ok := cache.collections[2344]; !ok || len(cache.collections) != 1 {
+		t.Errorf("got collections %v", cache.collections)
+	}
+
+	// nothing is evicted when evict_after is not set
+	cache = newCache(c.TMDbCacheConfig{}, map[string]Entry{"604": usedAt(365 * 24 * time.Hour)})
+	cache.evict(now)
+	if len(cache.entries) != 1 {
+		t.Error("an entry was evicted without evict_after")
+	}
+}
+
+func TestGetCollection(t *testing.T) {
+	now := time.Now()
+	cache := newCache(testConfig, map[string]Entry{})
+	cache.collections = map[int]Collection{
+		2344: {Parts: []CollectionPart{{ID: 603}}, FetchedAt: now.Add(-24 * time.Hour)},
+		2345: {Parts: []CollectionPart{{ID: 604}}, FetchedAt: now.Add(-31 * 24 * time.Hour)},
+	}
+
+	if parts, ok := cache.GetCollection(2344); !ok || len(parts) != 1 || parts[0].ID != 603 {
+		t.Errorf("got %v, %t", parts, ok)
+	}
+	if _, ok := cache.GetCollection(2345); ok {
+		t.Error("got a collection past the revenue ttl")
+	}
+	if _, ok := cache.GetCollection(2346); ok {
+		t.Error("got a missing collection")
+	}
+}